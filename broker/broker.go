@@ -1,65 +1,105 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
-	"log"
 	"math/rand"
 	"net"
 	"net/rpc"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 	"uk.ac.bris.cs/gameoflife/util"
 )
 
+// Broker serves each client the simulation of its session. The sessions run side by side,
+// sharing the servers in the pool.
 type Broker struct {
+	sessionsMutex sync.Mutex
+	sessions      map[string]*session
+	lastSession   int  // the number of the last session given an ID by the broker
+	terminating   bool // set once Terminate has begun shutting the broker down
 }
 
 var (
-	currentWorld            [][]byte
-	currentTurn             int
-	imageWidth, imageHeight int
-	allServers              []*rpc.Client
-	evolveMutex             sync.Mutex
-	pauseMutex              sync.Mutex
-	clientConnectionMutex   sync.Mutex
-	pauseBool               bool
-	resumeSignal            chan bool
-	quitSignal              chan bool
-	terminateSignal         chan bool
-	terminateBrokerSignal   chan bool
-	quitHappened            = false
-	terminateHappened       = false
-	clientConnected         = false
-	wg                      sync.WaitGroup
-	numberOfServers         = 4
+	terminateBrokerSignal chan bool
+	wg                    sync.WaitGroup
+	sessionTTL            time.Duration
 )
 
 func main() {
-	serverAddresses := flag.String("serverAddresses", "localhost:8050", "server addresses to call")
-	flag.Parse()
+	serverAddresses := flag.String("serverAddresses", "", "server addresses to call, in addition to servers that register themselves")
 	rand.Seed(time.Now().UnixNano())
 	pClientAddr := flag.String("clientPort", "8030", "Port to listen for clients on")
+	pWorkerAddr := flag.String("workerPort", "8040", "Port to listen for server registrations on")
+	flag.DurationVar(&workerTimeout, "workerTimeout", 5*time.Second, "How long to wait for a server before treating it as failed")
+	flag.DurationVar(&serverWait, "serverWait", time.Minute, "How long a simulation with no servers waits for one to register before stopping (0 to wait forever)")
+	flag.DurationVar(&syncInterval, "syncInterval", 2*time.Second, "How often each simulation brings its world back from the servers, bounding the turns lost when one fails")
+	flag.IntVar(&maxBatch, "maxBatch", 32, "Most turns a server may compute between halo exchanges")
+	flag.DurationVar(&batchTarget, "batchTarget", 100*time.Millisecond, "Preferred time for one batch of turns")
+	flag.StringVar(&checkpointFile, "checkpoint", "", "File to periodically save each simulation to, with its session ID added to the name (empty to disable)")
+	flag.DurationVar(&checkpointInterval, "checkpointInterval", time.Minute, "How often to write a checkpoint")
+	flag.IntVar(&flipsBuffer, "flipsBuffer", 256, "Turns of flipped cells kept for a client that falls behind")
+	flag.IntVar(&hashlifeMaxNodes, "hashlifeNodes", 4000000, "Quadtree nodes Hashlife may keep before starting afresh from the current world")
+	flag.DurationVar(&sessionTTL, "sessionTTL", 10*time.Minute, "How long a session that is not running is kept unused, for a client to attach to it or fetch its result (0 to keep it until closed)")
+	resume := flag.String("resume", "", "Checkpoint file to resume a simulation from, which runs in a new session for a client to attach to")
 	flag.Parse()
 
+	var saved *checkpoint
+	if *resume != "" {
+		var err error
+		saved, err = readCheckpoint(*resume)
+		if err != nil {
+			panic(fmt.Sprintf("Failed to read checkpoint: %v", err))
+		}
+	}
+
 	// Create an RPC broker instance
+	b := &Broker{sessions: make(map[string]*session)}
+	go b.reapSessions()
 	broker := rpc.NewServer()
-	err := broker.Register(&Broker{})
+	err := broker.Register(b)
 	if err != nil {
 		panic(err)
 	}
 
 	addresses := strings.Fields(*serverAddresses)
-	// dial all servers
+	// dial any servers given on the command line
 	for i, addr := range addresses {
-		server, err := rpc.Dial("tcp", addr)
+		err := addWorker(addr)
 		if err != nil {
 			panic(fmt.Sprintf("Failed to dial server %d: %v", i+1, err))
 		}
-		allServers = append(allServers, server)
 	}
-	fmt.Println("All servers connected")
+
+	// Servers register and deregister themselves on a separate port so that they are
+	// never queued behind a connected client.
+	workerListener, err := net.Listen("tcp", ":"+*pWorkerAddr)
+	if err != nil {
+		panic(err)
+	}
+	defer workerListener.Close()
+	go func() {
+		for {
+			conn, err := workerListener.Accept()
+			if err != nil {
+				return
+			}
+			go broker.ServeConn(conn)
+		}
+	}()
+	fmt.Println("Ready to accept servers")
+
+	if saved != nil {
+		id, err := b.resume(saved)
+		if err != nil {
+			panic(fmt.Sprintf("Failed to resume from checkpoint: %v", err))
+		}
+		fmt.Printf("Resumed from turn %v in session %v; attach to it with -attach\n", saved.Turn, id)
+	}
 
 	clientListener, err := net.Listen("tcp", ":"+*pClientAddr)
 	fmt.Println("Ready to accept client")
@@ -68,9 +108,6 @@ func main() {
 	}
 	defer clientListener.Close()
 
-	resumeSignal = make(chan bool)
-	quitSignal = make(chan bool)
-	terminateSignal = make(chan bool)
 	terminateBrokerSignal = make(chan bool)
 
 	// Channel to signal a new connection
@@ -89,12 +126,15 @@ func main() {
 			// Gracefully shut down the server
 			fmt.Println("Waiting for client to shut down")
 			wg.Wait()
+			workersMutex.Lock()
 			for _, server := range allServers {
-				err := server.Call(TerminateServerHandler, new(EmptyRequest), new(EmptyResponse))
+				// A server that has died without deregistering must not stop the others being told.
+				err := callWorker(server, TerminateServerHandler, new(EmptyRequest), new(EmptyResponse))
 				if err != nil {
-					log.Fatal(err)
+					fmt.Printf("Server %v failed to terminate: %v\n", server.address, err)
 				}
 			}
+			workersMutex.Unlock()
 			fmt.Println("Terminate signal received. Shutting down server...")
 			return
 		case connection := <-connChan:
@@ -104,156 +144,550 @@ func main() {
 }
 
 func handleClientConnection(connection net.Conn, server *rpc.Server) {
-	if clientConnected {
-		fmt.Println("A client is already connected. Waiting for space.")
-	}
-	clientConnectionMutex.Lock()
 	wg.Add(1)
-	clientConnected = true // Mark client as connected
+	fmt.Println("Client connected")
 	defer func() {
 		fmt.Println("Client connection closed")
-		clientConnected = false // Mark client as disconnected when done
 		connection.Close()
 		wg.Done()
-		clientConnectionMutex.Unlock()
 	}()
-	// Serve the connected client.
+	// Serve the connected client alongside any others.
 	server.ServeConn(connection)
-	fmt.Println("Client connected")
 }
 
-func calculateAliveCells() []util.Cell {
-	var aliveCells []util.Cell
+// open returns the session with the given ID, creating it if there is none. A session is given
+// the next free number as its ID if it is not given one.
+func (b *Broker) open(id string) (*session, bool, error) {
+	b.sessionsMutex.Lock()
+	defer b.sessionsMutex.Unlock()
+	if b.terminating {
+		return nil, false, errors.New("the broker is shutting down")
+	}
+	if id == "" {
+		for id == "" || b.sessions[id] != nil {
+			b.lastSession++
+			id = strconv.Itoa(b.lastSession)
+		}
+	}
+	if s := b.sessions[id]; s != nil {
+		s.lastUsed = time.Now()
+		return s, false, nil
+	}
+	s := newSession(id)
+	s.lastUsed = time.Now()
+	b.sessions[id] = s
+	return s, true, nil
+}
+
+// lookup returns the session with the given ID.
+func (b *Broker) lookup(id string) (*session, error) {
+	b.sessionsMutex.Lock()
+	defer b.sessionsMutex.Unlock()
+	s := b.sessions[id]
+	if s == nil {
+		return nil, fmt.Errorf("no session %q", id)
+	}
+	s.lastUsed = time.Now()
+	return s, nil
+}
 
-	for y := 0; y < imageHeight; y++ {
-		for x := 0; x < imageWidth; x++ {
-			if currentWorld[y][x] == 255 {
-				aliveCells = append(aliveCells, util.Cell{X: x, Y: y})
-			}
+// reap forgets the sessions that are not running and have been idle for longer than sessionTTL:
+// those whose client went away without closing them, and simulations that finished with no
+// client attached and that none has come back for.
+func (b *Broker) reap() {
+	b.sessionsMutex.Lock()
+	defer b.sessionsMutex.Unlock()
+	for id, s := range b.sessions {
+		if !s.isRunning() && time.Since(s.idleSince()) > sessionTTL {
+			fmt.Printf("Session %v unused for %v, forgetting it\n", id, sessionTTL)
+			delete(b.sessions, id)
 		}
 	}
-	return aliveCells
 }
 
-func (b *Broker) ReportAliveCells(req EmptyRequest, res *TickerResponse) (err error) {
-	evolveMutex.Lock()
-	res.AliveCells = calculateAliveCells()
-	res.Turn = currentTurn
-	evolveMutex.Unlock()
+// reapSessions reaps sessions for as long as the broker runs, unless sessionTTL is 0.
+func (b *Broker) reapSessions() {
+	for sessionTTL > 0 {
+		time.Sleep(sessionTTL / 4)
+		b.reap()
+	}
+}
+
+// allSessions returns every session, ordered by ID so that they are always locked in the same order.
+func (b *Broker) allSessions() []*session {
+	b.sessionsMutex.Lock()
+	defer b.sessionsMutex.Unlock()
+	sessions := make([]*session, 0, len(b.sessions))
+	for _, s := range b.sessions {
+		sessions = append(sessions, s)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].id < sessions[j].id })
+	return sessions
+}
+
+func (b *Broker) ReportAliveCells(req SessionRequest, res *TickerResponse) (err error) {
+	s, err := b.lookup(req.Session)
+	if err != nil {
+		return err
+	}
+	s.evolveMutex.Lock()
+	s.syncWorld()
+	res.AliveCells = s.world.AliveCells()
+	res.Turn = s.turn
+	res.CycleTurn, res.CyclePeriod = s.cycles.report()
+	s.evolveMutex.Unlock()
+	s.lostWorkersMutex.Lock()
+	res.LostWorkers = s.lostWorkers
+	s.lostWorkers = nil
+	s.lostWorkersMutex.Unlock()
+	return
+}
+
+func (b *Broker) InitialiseBoardAndTurn(req Request, res *SessionResponse) (err error) {
+	s, created, err := b.open(req.Session)
+	if err != nil {
+		return err
+	}
+	err = s.initialise(req.World, 0, req.P)
+	if err != nil {
+		if created {
+			b.sessionsMutex.Lock()
+			delete(b.sessions, s.id)
+			b.sessionsMutex.Unlock()
+		}
+		return err
+	}
+	res.Session = s.id
 	return
 }
 
-func (b *Broker) InitialiseBoardAndTurn(req Request, res *EmptyResponse) (err error) {
-	pauseBool = false
-	quitHappened = false
-	terminateHappened = false
-	currentWorld = req.World
-	currentTurn = 0
-	imageWidth = req.P.ImageWidth
-	imageHeight = req.P.ImageHeight
+// Attach finds the session a client attaching with the given ID joins. Without an ID, it is the
+// only simulation running, or failing that the only session there is.
+func (b *Broker) Attach(req SessionRequest, res *SessionResponse) (err error) {
+	if req.Session != "" {
+		_, err = b.lookup(req.Session)
+		res.Session = req.Session
+		return
+	}
+	sessions := b.allSessions()
+	var running []string
+	for _, s := range sessions {
+		if s.isRunning() {
+			running = append(running, s.id)
+		}
+	}
+	switch {
+	case len(running) == 1:
+		res.Session = running[0]
+	case len(running) > 1:
+		return fmt.Errorf("%v simulations are running, in sessions %v; choose one with -session", len(running), strings.Join(running, ", "))
+	case len(sessions) == 1:
+		res.Session = sessions[0].id
+	default:
+		return errors.New("no simulation is running to attach to")
+	}
 	return
 }
 
-func (b *Broker) CurrentWorldState(req EmptyRequest, res *Response) (err error) {
-	evolveMutex.Lock()
-	res.FinalBoard = currentWorld
-	res.Turn = currentTurn
-	res.Paused = pauseBool
-	evolveMutex.Unlock()
+// CloseSession forgets a session once its client is done with it, unless its simulation is
+// still running for a client to attach to later.
+func (b *Broker) CloseSession(req SessionRequest, res *EmptyResponse) (err error) {
+	b.sessionsMutex.Lock()
+	defer b.sessionsMutex.Unlock()
+	if s := b.sessions[req.Session]; s != nil && !s.isRunning() {
+		delete(b.sessions, req.Session)
+	}
 	return
 }
 
-func sendWork(p Params, resultsChannel chan<- [][]byte, server *rpc.Client, serverNumber int) {
-	req := Request{P: p, World: currentWorld, ServerNumber: serverNumber}
-	res := new(ServerSliceResponse)
-	err := server.Call(CalculateNextStateHandler, req, res)
+// resume opens a session carrying on from a checkpoint and starts its simulation without a
+// client, for one to attach to. It returns the session's ID.
+func (b *Broker) resume(saved *checkpoint) (string, error) {
+	s, _, err := b.open("")
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
-	resultsChannel <- res.Slice
+	err = s.initialise(saved.World, saved.Turn, saved.P)
+	if err != nil {
+		b.sessionsMutex.Lock()
+		delete(b.sessions, s.id)
+		b.sessionsMutex.Unlock()
+		return "", err
+	}
+	s.simulationMutex.Lock()
+	s.start(s.params)
+	s.simulationMutex.Unlock()
+	return s.id, nil
 }
 
-func assembleNewWorld(resultsChannel []chan [][]byte, p Params) [][]byte {
-	newWorld := make([][]byte, 0, p.ImageHeight)
-	for i := 0; i < numberOfServers; i++ {
-		newWorld = append(newWorld, <-resultsChannel[i]...)
+// initialise loads the world for a new simulation, which starts at the given turn.
+func (s *session) initialise(world util.BitBoard, turn int, p Params) error {
+	if s.isRunning() {
+		return errors.New("a simulation is already running; attach to it or quit it first")
+	}
+	rule, err := util.ParseRule(p.Rule)
+	if err != nil {
+		return err
 	}
-	return newWorld
+	if world.AgePlanes != util.AgePlanes(rule.States) {
+		return fmt.Errorf("a world for rule %v needs %v age planes, not %v", rule, util.AgePlanes(rule.States), world.AgePlanes)
+	}
+	topology, err := util.ParseTopology(p.Topology)
+	if err != nil {
+		return err
+	}
+	switch p.Algorithm {
+	case "", "strips":
+	case "hashlife":
+		err = checkHashlife(p, rule, topology)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown algorithm %q, want strips or hashlife", p.Algorithm)
+	}
+
+	s.evolveMutex.Lock()
+	defer s.evolveMutex.Unlock()
+	s.simulationDone = nil
+	s.paused = false
+	s.quitHappened = false
+	s.terminateHappened = false
+	s.world = world
+	s.turn = turn
+	s.params = p
+	s.topology = topology
+	s.worldTurn = s.turn
+	s.strips = nil
+	s.serversLost = false
+	s.hashlife = nil
+	s.lastSync = time.Now()
+	s.lastCheckpoint = time.Now()
+	s.flips.reset(s.turn)
+	s.resetCycles()
+	return nil
 }
 
-func (b *Broker) Quit(req KeyPressed, res *EmptyResponse) (err error) {
-	quitHappened = true
-	quitSignal <- true
+func (b *Broker) CurrentWorldState(req SessionRequest, res *Response) (err error) {
+	s, err := b.lookup(req.Session)
+	if err != nil {
+		return err
+	}
+	s.evolveMutex.Lock()
+	s.syncWorld()
+	res.FinalBoard = s.world
+	res.Turn = s.turn
+	res.Paused = s.paused
+	res.P = s.params
+	s.evolveMutex.Unlock()
+	return
+}
+
+func (b *Broker) RegisterWorker(req ServerAddress, res *EmptyResponse) (err error) {
+	return addWorker(req.Address)
+}
+
+func (b *Broker) DeregisterWorker(req ServerAddress, res *EmptyResponse) (err error) {
+	// Wait for the current turn of every session to finish and collect the server's strips
+	// before removing it.
+	sessions := b.allSessions()
+	for _, s := range sessions {
+		s.evolveMutex.Lock()
+		s.syncWorld()
+	}
+	removeWorker(req.Address)
+	for _, s := range sessions {
+		s.evolveMutex.Unlock()
+	}
 	return
 }
 
-func (b *Broker) Terminate(req KeyPressed, res *EmptyResponse) (err error) {
-	terminateHappened = true
-	terminateSignal <- true
+func (b *Broker) Quit(req SessionRequest, res *EmptyResponse) (err error) {
+	s, err := b.lookup(req.Session)
+	if err != nil {
+		return err
+	}
+	if !s.isRunning() {
+		return
+	}
+	s.pauseMutex.Lock()
+	s.quitHappened = true
+	s.pauseMutex.Unlock()
+	s.signal(s.quitSignal)
+	return
+}
+
+// Terminate stops the caller's simulation and shuts the broker down along with its servers. It
+// is refused while other simulations are running, as they share the servers.
+func (b *Broker) Terminate(req SessionRequest, res *EmptyResponse) (err error) {
+	b.sessionsMutex.Lock()
+	s := b.sessions[req.Session]
+	if s == nil {
+		b.sessionsMutex.Unlock()
+		return fmt.Errorf("no session %q", req.Session)
+	}
+	var others []string
+	for id, other := range b.sessions {
+		if other != s && other.isRunning() {
+			others = append(others, id)
+		}
+	}
+	if len(others) > 0 {
+		b.sessionsMutex.Unlock()
+		sort.Strings(others)
+		return fmt.Errorf("other simulations are running, in sessions %v; quit them before terminating the broker", strings.Join(others, ", "))
+	}
+	// No session can start now, so none is left running when the servers are shut down.
+	b.terminating = true
+	b.sessionsMutex.Unlock()
+
+	s.pauseMutex.Lock()
+	s.terminateHappened = true
+	s.pauseMutex.Unlock()
+	s.signal(s.terminateSignal)
 	terminateBrokerSignal <- true
 	return
 }
 
-func (b *Broker) Pause(req KeyPressed, res *EmptyResponse) (err error) {
-	pauseMutex.Lock()
-	pauseBool = !pauseBool
-	pauseMutex.Unlock()
-	if !pauseBool {
-		resumeSignal <- true
+func (b *Broker) Pause(req SessionRequest, res *EmptyResponse) (err error) {
+	s, err := b.lookup(req.Session)
+	if err != nil {
+		return err
+	}
+	s.pauseMutex.Lock()
+	s.paused = !s.paused
+	paused := s.paused
+	if !paused {
+		s.finishSteps()
+	}
+	s.pauseMutex.Unlock()
+	if !paused {
+		s.signal(s.resumeSignal)
 	}
 	return
 }
 
+// Advance runs a paused simulation for the given number of turns, returning the turn it has
+// reached once it has paused again or the simulation has stopped.
+func (b *Broker) Advance(req AdvanceRequest, res *Response) (err error) {
+	s, err := b.lookup(req.Session)
+	if err != nil {
+		return err
+	}
+	if req.Turns < 1 {
+		return fmt.Errorf("cannot advance by %v turns", req.Turns)
+	}
+	s.simulationMutex.Lock()
+	running, done := s.simulationRunning, s.simulationDone
+	s.simulationMutex.Unlock()
+	if !running {
+		return errors.New("the simulation is not running")
+	}
+	s.pauseMutex.Lock()
+	if !s.paused {
+		s.pauseMutex.Unlock()
+		return errors.New("the simulation must be paused to advance it")
+	}
+	if s.stepDone != nil {
+		s.pauseMutex.Unlock()
+		return errors.New("the simulation is already advancing")
+	}
+	stepped := make(chan struct{})
+	s.stepBudget = req.Turns
+	s.stepDone = stepped
+	s.pauseMutex.Unlock()
+	select {
+	case s.stepSignal <- true:
+	default:
+	}
+
+	select {
+	case <-stepped:
+	case <-done:
+	}
+	s.evolveMutex.Lock()
+	res.Turn = s.turn
+	s.evolveMutex.Unlock()
+	return
+}
+
+// Detach releases the client waiting in Evolve while the simulation carries on, so that it
+// can disconnect and another client can attach later.
+func (b *Broker) Detach(req SessionRequest, res *EmptyResponse) (err error) {
+	s, err := b.lookup(req.Session)
+	if err != nil {
+		return err
+	}
+	s.simulationMutex.Lock()
+	defer s.simulationMutex.Unlock()
+	if s.detachSignal != nil {
+		close(s.detachSignal)
+		s.detachSignal = nil
+		s.flips.stopRecording()
+		fmt.Printf("Client detached from session %v\n", s.id)
+	}
+	return
+}
+
+// Evolve starts the simulation if it is not already running, then waits for it to finish.
+// A client that attaches to a running simulation calls Evolve to wait alongside it.
 func (b *Broker) Evolve(req Request, res *Response) (err error) {
-	p := req.P
+	s, err := b.lookup(req.Session)
+	if err != nil {
+		return err
+	}
+	s.simulationMutex.Lock()
+	done := s.start(req.P)
+	detached := make(chan struct{})
+	s.detachSignal = detached
+	if req.P.Stream {
+		s.flips.startRecording()
+	} else {
+		s.flips.stopRecording()
+	}
+	s.simulationMutex.Unlock()
+
+	select {
+	case <-done:
+		*res = s.simulationResult
+	case <-detached:
+		res.Detached = true
+		s.evolveMutex.Lock()
+		res.Turn = s.turn
+		s.evolveMutex.Unlock()
+	}
+	return
+}
 
-	resultsChannel := make([]chan [][]byte, 4)
-	for i := 0; i < 4; i++ {
-		resultsChannel[i] = make(chan [][]byte)
+// start runs the simulation with the given params unless it has been started already, and
+// returns the channel closed when it is over. s.simulationMutex must be held.
+func (s *session) start(p Params) chan struct{} {
+	if s.simulationDone == nil {
+		s.simulationDone = make(chan struct{})
+		s.simulationRunning = true
+		go s.run(p)
 	}
+	return s.simulationDone
+}
 
-	// Execute all turns of the Game of Life.
-	for currentTurn < p.Turns {
-		evolveMutex.Lock()
-		// send work to servers
-		for i, server := range allServers {
-			go sendWork(p, resultsChannel[i], server, i)
+// run executes the turns of the Game of Life until they are done or the client quits.
+func (s *session) run(p Params) {
+	res := new(Response)
+	hashlife := s.params.Algorithm == "hashlife"
+	if !hashlife {
+		joinPool(s)
+	}
+	defer func() {
+		if !hashlife {
+			// Bring the world back from the servers so they can drop the session's strips.
+			s.evolveMutex.Lock()
+			s.syncWorld()
+			s.releaseStrips(nil)
+			s.strips = nil
+			s.evolveMutex.Unlock()
+			leavePool(s)
 		}
+		s.simulationMutex.Lock()
+		s.simulationResult = *res
+		s.simulationRunning = false
+		s.simulationEnded = time.Now()
+		close(s.simulationDone)
+		s.simulationMutex.Unlock()
+	}()
+
+	if hashlife {
+		s.evolveMutex.Lock()
+		s.startHashlife()
+		s.evolveMutex.Unlock()
+	}
 
-		currentWorld = assembleNewWorld(resultsChannel, p)
-		currentTurn++
-		evolveMutex.Unlock()
-		pauseMutex.Lock()
-		if terminateHappened {
+	// Execute all turns of the Game of Life, in batches so that the servers are not
+	// limited by a round trip per turn.
+	lastBatch := time.Duration(0)
+	for s.turn < p.Turns {
+		s.pauseMutex.Lock()
+		stepping, budget := s.paused, s.stepBudget
+		s.pauseMutex.Unlock()
+		s.evolveMutex.Lock()
+		remainingTurns := s.cycleRemainingTurns(p)
+		if remainingTurns == 0 {
+			s.skipCycles(p)
+			s.evolveMutex.Unlock()
+			break
+		}
+		if stepping && budget < remainingTurns {
+			// While paused, only the turns Advance asked for are run.
+			remainingTurns = budget
+		}
+		startTurn := s.turn
+		if remainingTurns > 0 {
+			start := time.Now()
+			if hashlife {
+				s.hashlifeTurns(s.nextHashlifeStep(lastBatch, remainingTurns))
+				s.flips.skip(s.turn)
+			} else {
+				if s.strips == nil || poolChangedSince(s.poolVersion) {
+					s.syncWorld()
+					s.distributeWorld()
+				}
+				if !s.serversLost {
+					s.stepStrips(s.nextBatchSize(lastBatch, remainingTurns))
+				}
+			}
+			lastBatch = time.Since(start)
+		}
+		advanced := s.turn - startTurn
+		s.syncIfDue()
+		s.checkpointIfDue()
+		if s.serversLost {
+			res.WorkersLost = true
+			res.Turn = s.turn
+			res.FinalBoard = s.world
+			s.evolveMutex.Unlock()
+			return
+		}
+		s.evolveMutex.Unlock()
+		s.pauseMutex.Lock()
+		if stepping {
+			s.spendSteps(advanced)
+		}
+		if s.terminateHappened {
 			res.Terminated = true
-			<-terminateSignal
-			pauseMutex.Unlock()
+			<-s.terminateSignal
+			s.pauseMutex.Unlock()
 			return
-		} else if quitHappened {
+		} else if s.quitHappened {
 			res.Quit = true
-			<-quitSignal
-			pauseMutex.Unlock()
+			<-s.quitSignal
+			s.pauseMutex.Unlock()
 			return
-		} else if pauseBool {
-			pauseMutex.Unlock()
+		} else if s.paused && s.stepBudget > 0 {
+			s.pauseMutex.Unlock()
+		} else if s.paused {
+			s.pauseMutex.Unlock()
 			select {
-			case <-resumeSignal:
+			case <-s.resumeSignal:
+				continue
+			case <-s.stepSignal:
 				continue
-			case <-quitSignal:
+			case <-s.quitSignal:
 				res.Quit = true
 				return
-			case <-terminateSignal:
+			case <-s.terminateSignal:
 				res.Terminated = true
 				return
 			}
 		} else {
-			pauseMutex.Unlock()
+			s.pauseMutex.Unlock()
 		}
 	}
 
 	// Allow turn number and final board to be used by client
-	res.Turn = currentTurn
-	res.FinalBoard = currentWorld
+	s.evolveMutex.Lock()
+	s.syncWorld()
+	res.Turn = s.turn
+	res.FinalBoard = s.world
+	s.evolveMutex.Unlock()
 
 	return
 }
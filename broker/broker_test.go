@@ -0,0 +1,112 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestReap checks that only sessions that are not running and have been idle for longer than
+// sessionTTL are forgotten, counting a simulation stopping as use.
+func TestReap(t *testing.T) {
+	defer func(ttl time.Duration) { sessionTTL = ttl }(sessionTTL)
+	sessionTTL = time.Minute
+	long := time.Now().Add(-2 * time.Minute)
+
+	b := &Broker{sessions: make(map[string]*session)}
+	add := func(id string, lastUsed time.Time, running bool, ended time.Time) {
+		s := newSession(id)
+		s.lastUsed = lastUsed
+		s.simulationRunning = running
+		s.simulationEnded = ended
+		b.sessions[id] = s
+	}
+	add("abandoned", long, false, time.Time{})
+	add("finished", long, false, long)
+	add("running", long, true, time.Time{})
+	add("used", time.Now(), false, long)
+	add("just finished", long, false, time.Now())
+	b.reap()
+
+	for _, id := range []string{"abandoned", "finished"} {
+		if b.sessions[id] != nil {
+			t.Errorf("session %q was kept", id)
+		}
+	}
+	for _, id := range []string{"running", "used", "just finished"} {
+		if b.sessions[id] == nil {
+			t.Errorf("session %q was forgotten", id)
+		}
+	}
+	if _, err := b.lookup("finished"); err == nil {
+		t.Error("found a forgotten session")
+	}
+}
+
+// TestAdvance checks that a paused simulation runs exactly the turns Advance asks for, and no
+// further than its last turn, and that the world is then that of the turn reached.
+func TestAdvance(t *testing.T) {
+	// Hashlife runs in the broker itself, so no servers are needed.
+	size, turns := 32, 50
+	rule, _ := util.ParseRule("B3/S23")
+	rng := rand.New(rand.NewSource(1))
+	world := util.NewBitBoard(size, size)
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			world.Set(x, y, rng.Intn(3) == 0)
+		}
+	}
+	p := Params{Turns: turns, ImageWidth: size, ImageHeight: size, Rule: rule.String(), Algorithm: "hashlife"}
+	b := &Broker{sessions: make(map[string]*session)}
+	opened := new(SessionResponse)
+	if err := b.InitialiseBoardAndTurn(Request{P: p, World: world}, opened); err != nil {
+		t.Fatal(err)
+	}
+	id := opened.Session
+	if err := b.Pause(SessionRequest{Session: id}, new(EmptyResponse)); err != nil {
+		t.Fatal(err)
+	}
+	s, _ := b.lookup(id)
+	s.simulationMutex.Lock()
+	done := s.start(p)
+	s.simulationMutex.Unlock()
+
+	want, wantTurn := world, 0
+	for _, advance := range []int{1, 5, 1, 37, 20} {
+		res := new(Response)
+		if err := b.Advance(AdvanceRequest{Session: id, Turns: advance}, res); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < advance && wantTurn < turns; i++ {
+			want = stepTorus(want, rule)
+			wantTurn++
+		}
+		if res.Turn != wantTurn {
+			t.Fatalf("advanced by %v to turn %v, want %v", advance, res.Turn, wantTurn)
+		}
+		state := new(Response)
+		if err := b.CurrentWorldState(SessionRequest{Session: id}, state); err != nil {
+			t.Fatal(err)
+		}
+		if state.Turn != wantTurn {
+			t.Fatalf("turn %v after advancing, want %v", state.Turn, wantTurn)
+		}
+		if wantTurn < turns && !state.Paused {
+			t.Fatalf("turn %v: not paused after advancing", wantTurn)
+		}
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				if state.FinalBoard.Alive(x, y) != want.Alive(x, y) {
+					t.Fatalf("turn %v: cell (%v, %v) is alive %v, want %v", wantTurn, x, y, state.FinalBoard.Alive(x, y), want.Alive(x, y))
+				}
+			}
+		}
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the simulation did not end after advancing past its last turn")
+	}
+}
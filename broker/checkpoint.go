@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// checkpoint is everything needed to carry on a simulation after the broker restarts.
+type checkpoint struct {
+	World util.BitBoard
+	Turn  int
+	P     Params
+}
+
+var (
+	checkpointFile     string
+	checkpointInterval time.Duration
+)
+
+// checkpointPath is the file a session's checkpoints are written to: checkpointFile with the
+// session's ID added before the extension, so that sessions do not overwrite each other's.
+func (s *session) checkpointPath() string {
+	ext := filepath.Ext(checkpointFile)
+	return fmt.Sprintf("%v-%v%v", strings.TrimSuffix(checkpointFile, ext), s.id, ext)
+}
+
+// writeCheckpoint saves the session's world to its checkpoint file. It is written to a temporary
+// file first so that a crash part way through never leaves a truncated checkpoint behind.
+func (s *session) writeCheckpoint() error {
+	s.syncWorld()
+	path := s.checkpointPath()
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = gob.NewEncoder(file).Encode(checkpoint{World: s.world, Turn: s.worldTurn, P: s.params})
+	if err == nil {
+		err = file.Sync()
+	}
+	file.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// checkpointIfDue writes a checkpoint when checkpointing is enabled and the interval has passed.
+func (s *session) checkpointIfDue() {
+	if checkpointFile == "" || time.Since(s.lastCheckpoint) < checkpointInterval {
+		return
+	}
+	s.lastCheckpoint = time.Now()
+	err := s.writeCheckpoint()
+	if err != nil {
+		fmt.Println("Failed to write checkpoint:", err)
+		return
+	}
+	fmt.Printf("Checkpoint of session %v written at turn %v\n", s.id, s.worldTurn)
+}
+
+// readCheckpoint loads a checkpoint written by writeCheckpoint.
+func readCheckpoint(filename string) (*checkpoint, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	saved := new(checkpoint)
+	err = gob.NewDecoder(file).Decode(saved)
+	if err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
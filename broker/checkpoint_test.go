@@ -0,0 +1,95 @@
+package main
+
+import (
+	"math/rand"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestCheckpointResume checks that a simulation checkpointed part way through and resumed in a
+// new session ends with the same world as one run without stopping.
+func TestCheckpointResume(t *testing.T) {
+	defer func(file string, interval time.Duration) {
+		checkpointFile, checkpointInterval = file, interval
+	}(checkpointFile, checkpointInterval)
+	checkpointInterval = 0
+
+	// Hashlife runs in the broker itself, so no servers are needed.
+	size, turns, stopAt := 64, 300, 77
+	rng := rand.New(rand.NewSource(1))
+	world := util.NewBitBoard(size, size)
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			world.Set(x, y, rng.Intn(3) == 0)
+		}
+	}
+	p := Params{Turns: turns, ImageWidth: size, ImageHeight: size, Rule: "B3/S23", Algorithm: "hashlife"}
+	b := &Broker{sessions: make(map[string]*session)}
+
+	run := new(SessionResponse)
+	if err := b.InitialiseBoardAndTurn(Request{P: p, World: world}, run); err != nil {
+		t.Fatal(err)
+	}
+	want := new(Response)
+	if err := b.Evolve(Request{P: p, Session: run.Session}, want); err != nil {
+		t.Fatal(err)
+	}
+
+	// Stop the second run once it has checkpointed the turn it was advanced to. Only it writes
+	// checkpoints, after every batch.
+	checkpointFile = filepath.Join(t.TempDir(), "world.gob")
+	stopped := new(SessionResponse)
+	if err := b.InitialiseBoardAndTurn(Request{P: p, World: world}, stopped); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Pause(SessionRequest{Session: stopped.Session}, new(EmptyResponse)); err != nil {
+		t.Fatal(err)
+	}
+	s, _ := b.lookup(stopped.Session)
+	s.simulationMutex.Lock()
+	done := s.start(p)
+	s.simulationMutex.Unlock()
+	advanced := new(Response)
+	if err := b.Advance(AdvanceRequest{Session: stopped.Session, Turns: stopAt}, advanced); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Quit(SessionRequest{Session: stopped.Session}, new(EmptyResponse)); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	path := s.checkpointPath()
+	checkpointFile = ""
+	if advanced.Turn != stopAt {
+		t.Fatalf("advanced to turn %v, want %v", advanced.Turn, stopAt)
+	}
+
+	saved, err := readCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Turn != stopAt {
+		t.Fatalf("checkpoint of turn %v, want %v", saved.Turn, stopAt)
+	}
+	id, err := b.resume(saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := new(Response)
+	if err := b.Evolve(Request{P: p, Session: id}, got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Turn != want.Turn {
+		t.Fatalf("resumed run ended at turn %v, want %v", got.Turn, want.Turn)
+	}
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if got.FinalBoard.Alive(x, y) != want.FinalBoard.Alive(x, y) {
+				t.Fatalf("cell (%v, %v) is alive %v after resuming, want %v", x, y, got.FinalBoard.Alive(x, y), want.FinalBoard.Alive(x, y))
+			}
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import "fmt"
+
+// turnHash is the hash of the world after a turn.
+type turnHash struct {
+	turn int
+	hash uint64
+}
+
+// cycleDetector looks for a session's world repeating itself.
+type cycleDetector struct {
+	hashes   []turnHash // the hashes of the last params.MaxPeriod turns, oldest first
+	turn     int
+	period   int // 0 until a cycle is found
+	reported bool
+	endTurn  int // the turn to skip ahead from when StopOnCycle is set, or -1
+}
+
+// report returns the turn and period of the cycle found, if it has not been reported yet.
+func (c *cycleDetector) report() (int, int) {
+	if c.period == 0 || c.reported {
+		return 0, 0
+	}
+	c.reported = true
+	return c.turn, c.period
+}
+
+// rewind forgets the hashes of turns after the given one, which have been lost in a rollback.
+func (c *cycleDetector) rewind(turn int) {
+	for len(c.hashes) > 0 && c.hashes[len(c.hashes)-1].turn > turn {
+		c.hashes = c.hashes[:len(c.hashes)-1]
+	}
+}
+
+// resetCycles forgets any cycle for a simulation starting from the session's world and turn.
+func (s *session) resetCycles() {
+	s.cycles = cycleDetector{endTurn: -1}
+	if s.detectingCycles() {
+		s.recordHash(s.turn, s.world.Hash())
+	}
+}
+
+// detectingCycles reports whether the servers should hash every turn.
+func (s *session) detectingCycles() bool {
+	return s.params.MaxPeriod > 0 && s.cycles.period == 0
+}
+
+// recordHashes adds the hashes of a batch of turns after firstTurn. Each server hashes its own
+// strip, and the hash of the world is the sum of the hashes of the strips.
+func (s *session) recordHashes(firstTurn int, results []HaloResponse, turns int) {
+	for t := 0; t < turns && s.cycles.period == 0; t++ {
+		var hash uint64
+		for _, result := range results {
+			hash += result.Hashes[t]
+		}
+		s.recordHash(firstTurn+t+1, hash)
+	}
+}
+
+// recordHash adds the hash of the world after a turn, checking whether the world has been seen
+// in any of the recent turns.
+func (s *session) recordHash(turn int, hash uint64) {
+	c := &s.cycles
+	for i := len(c.hashes) - 1; i >= 0; i-- {
+		if c.hashes[i].hash == hash {
+			c.turn = turn
+			c.period = turn - c.hashes[i].turn
+			c.hashes = nil
+			fmt.Printf("Cycle of period %v found at turn %v\n", c.period, c.turn)
+			return
+		}
+	}
+	c.hashes = append(c.hashes, turnHash{turn, hash})
+	if len(c.hashes) > s.params.MaxPeriod {
+		c.hashes = c.hashes[1:]
+	}
+}
+
+// cycleRemainingTurns limits the turns still to run when StopOnCycle is set and a cycle has been
+// found: only enough turns are run to reach the same point in the cycle as the last turn.
+func (s *session) cycleRemainingTurns(p Params) int {
+	c := &s.cycles
+	if !p.StopOnCycle || c.period == 0 {
+		return p.Turns - s.turn
+	}
+	if c.endTurn < 0 {
+		c.endTurn = s.turn + (p.Turns-s.turn)%c.period
+	}
+	return c.endTurn - s.turn
+}
+
+// skipCycles jumps to the last turn, which the world is now the same as.
+func (s *session) skipCycles(p Params) {
+	s.syncWorld()
+	fmt.Printf("Skipping from turn %v to turn %v\n", s.turn, p.Turns)
+	s.turn = p.Turns
+	s.worldTurn = p.Turns
+	s.flips.skip(s.turn)
+}
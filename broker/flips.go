@@ -0,0 +1,126 @@
+package main
+
+import (
+	"sync"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+var flipsBuffer int
+
+// flipsLog is the cells flipped in the recent turns of a session, for streaming to its client.
+type flipsLog struct {
+	mutex  sync.Mutex
+	record bool
+	turns  []TurnFlips // the cells flipped in turns from+1 onwards
+	from   int
+}
+
+// reset empties the log for a simulation starting at the given turn.
+func (f *flipsLog) reset(turn int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.turns = nil
+	f.from = turn
+}
+
+// startRecording is called when a client is waiting for the simulation and has a window that
+// wants the flipped cells of every turn streamed to it.
+func (f *flipsLog) startRecording() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record = true
+}
+
+// stopRecording is called when no client is left to stream the flipped cells to, or the one
+// waiting has nothing to show them in.
+func (f *flipsLog) stopRecording() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.record = false
+	f.turns = nil
+}
+
+// isRecording reports whether the servers should send back the cells they flip.
+func (f *flipsLog) isRecording() bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.record
+}
+
+// log adds the flipped cells of a batch of turns, one entry per turn starting after firstTurn.
+// Only the last flipsBuffer turns are kept; clients further behind than that resynchronise.
+func (f *flipsLog) log(firstTurn int, results []HaloResponse, turns int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if !f.record {
+		return
+	}
+	if firstTurn != f.from+len(f.turns) {
+		// Recording has only just started, so there is nothing earlier to join on to.
+		f.turns = nil
+		f.from = firstTurn
+	}
+	for t := 0; t < turns; t++ {
+		var cells []util.Cell
+		for _, result := range results {
+			cells = append(cells, result.Flips[t]...)
+		}
+		f.turns = append(f.turns, TurnFlips{Turn: firstTurn + t + 1, Cells: cells})
+	}
+	if len(f.turns) > flipsBuffer {
+		drop := len(f.turns) - flipsBuffer
+		f.turns = append([]TurnFlips(nil), f.turns[drop:]...)
+		f.from += drop
+	}
+}
+
+// skip empties the log after turns that were computed without recording their flips, so
+// that clients resynchronise with the world at the given turn.
+func (f *flipsLog) skip(turn int) {
+	f.reset(turn)
+}
+
+// rewind forgets the flips of turns after the given one, which have been lost in a rollback.
+func (f *flipsLog) rewind(turn int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if turn < f.from {
+		f.turns = nil
+		f.from = turn
+	} else if turn-f.from < len(f.turns) {
+		f.turns = f.turns[:turn-f.from]
+	}
+}
+
+// since returns the flips of each turn after the given one, if they are still kept.
+func (f *flipsLog) since(turn int, res *FlipsResponse) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if turn < f.from || turn > f.from+len(f.turns) {
+		return false
+	}
+	res.Turns = append(res.Turns, f.turns[turn-f.from:]...)
+	res.Turn = f.from + len(f.turns)
+	return true
+}
+
+// Flips returns the cells flipped in each turn after req.Turn. If those turns are no longer
+// kept, the whole current world is returned instead for the client to compare against.
+func (b *Broker) Flips(req FlipsRequest, res *FlipsResponse) (err error) {
+	s, err := b.lookup(req.Session)
+	if err != nil {
+		return err
+	}
+	if s.flips.since(req.Turn, res) {
+		return
+	}
+
+	s.evolveMutex.Lock()
+	defer s.evolveMutex.Unlock()
+	s.syncWorld()
+	res.Resync = true
+	res.World = s.world
+	res.Turn = s.worldTurn
+	return
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// flippedCells lists the cells that differ between two boards, split at row split into the
+// cells from the strip above it and those from the strip below.
+func flippedCells(before, after util.BitBoard, split int) (above, below []util.Cell) {
+	for y := 0; y < before.Height; y++ {
+		for x := 0; x < before.Width; x++ {
+			if before.Alive(x, y) == after.Alive(x, y) {
+				continue
+			}
+			if y < split {
+				above = append(above, util.Cell{X: x, Y: y})
+			} else {
+				below = append(below, util.Cell{X: x, Y: y})
+			}
+		}
+	}
+	return
+}
+
+// copyBoard returns a copy of a board that flipping cells in leaves the original alone.
+func copyBoard(board util.BitBoard) util.BitBoard {
+	c := util.NewBitBoard(board.Width, board.Height)
+	for _, cell := range board.AliveCells() {
+		c.Set(cell.X, cell.Y, true)
+	}
+	return c
+}
+
+// TestFlips checks that a client applying the flipped cells it is streamed to its own copy of
+// the initial board keeps up with the world, including after falling too far behind for the
+// log and being resynchronised with the whole world.
+func TestFlips(t *testing.T) {
+	defer func(buffer int) { flipsBuffer = buffer }(flipsBuffer)
+	flipsBuffer = 4
+
+	size, turns, batch := 32, 30, 3
+	rule, _ := util.ParseRule("B3/S23")
+	rng := rand.New(rand.NewSource(1))
+	boards := []util.BitBoard{util.NewBitBoard(size, size)}
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			boards[0].Set(x, y, rng.Intn(3) == 0)
+		}
+	}
+	for turn := 1; turn <= turns; turn++ {
+		boards = append(boards, stepTorus(boards[turn-1], rule))
+	}
+
+	b := &Broker{sessions: make(map[string]*session)}
+	s, _, _ := b.open("1")
+	s.params = Params{Turns: turns, ImageWidth: size, ImageHeight: size, Rule: rule.String()}
+	s.world = boards[0]
+	s.flips.reset(0)
+	s.flips.startRecording()
+
+	view := copyBoard(boards[0])
+	viewTurn, resyncs := 0, 0
+	for first := 0; first < turns; first += batch {
+		// Two servers each send back the cells they flipped in every turn of the batch.
+		results := make([]HaloResponse, 2)
+		for turn := first + 1; turn <= first+batch; turn++ {
+			above, below := flippedCells(boards[turn-1], boards[turn], size/2)
+			results[0].Flips = append(results[0].Flips, above)
+			results[1].Flips = append(results[1].Flips, below)
+		}
+		s.flips.log(first, results, batch)
+		s.turn = first + batch
+		s.worldTurn = s.turn
+		s.world = boards[s.turn]
+
+		if s.turn >= 9 && s.turn <= 15 {
+			// The client falls behind by more turns than are kept.
+			continue
+		}
+		res := new(FlipsResponse)
+		if err := b.Flips(FlipsRequest{Session: "1", Turn: viewTurn}, res); err != nil {
+			t.Fatal(err)
+		}
+		if res.Resync {
+			resyncs++
+			view = copyBoard(res.World)
+		} else {
+			for _, turn := range res.Turns {
+				if turn.Turn != viewTurn+1 {
+					t.Fatalf("flips of turn %v after turn %v", turn.Turn, viewTurn)
+				}
+				for _, cell := range turn.Cells {
+					view.Set(cell.X, cell.Y, !view.Alive(cell.X, cell.Y))
+				}
+				viewTurn = turn.Turn
+			}
+		}
+		viewTurn = res.Turn
+		if viewTurn != s.turn {
+			t.Fatalf("client is at turn %v, want %v", viewTurn, s.turn)
+		}
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				if view.Alive(x, y) != boards[viewTurn].Alive(x, y) {
+					t.Fatalf("turn %v: cell (%v, %v) is alive %v, want %v", viewTurn, x, y, view.Alive(x, y), boards[viewTurn].Alive(x, y))
+				}
+			}
+		}
+	}
+	if resyncs != 1 {
+		t.Errorf("%v resyncs, want 1 after falling behind", resyncs)
+	}
+}
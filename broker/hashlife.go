@@ -0,0 +1,287 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// Hashlife is Gosper's algorithm: the world is a quadtree of canonical nodes, and the result of
+// advancing each node is memoised, so repetitive patterns can be run for billions of turns.
+//
+// The world is a torus, so a node made of copies of the world tiled side by side stands for the
+// torus itself. Advancing a tiling of size 2^m by up to 2^(m-2) turns gives back its centre,
+// which is again a tiling of the world shifted by a quarter of the tiling. Any world-sized corner
+// of it is a copy of the world, so nothing needs to be rebuilt between steps apart from tracking
+// the shift.
+
+// node is a square of 2^level cells. Level 0 nodes are single cells; others have four children.
+type node struct {
+	nw, ne, sw, se *node
+	level          int
+	population     int
+}
+
+type nodeKey struct {
+	nw, ne, sw, se *node
+}
+
+type advanceKey struct {
+	n    *node
+	step int
+}
+
+// hashlife is the quadtree of a session running with Hashlife.
+type hashlife struct {
+	rule    util.Rule
+	nodes   map[nodeKey]*node
+	results map[advanceKey]*node
+	empty   []*node
+	world   *node // the world, shifted left and up by offset cells
+	offset  int
+	step    int // log2 of the turns in the next batch
+}
+
+var (
+	hashlifeMaxNodes int
+	deadCell         = &node{}
+	aliveCell        = &node{population: 1}
+)
+
+// checkHashlife reports why a simulation cannot be run with Hashlife, if it cannot.
+func checkHashlife(p Params, rule util.Rule, topology util.Topology) error {
+	size := p.ImageWidth
+	if size != p.ImageHeight || size < 2 || size&(size-1) != 0 {
+		return fmt.Errorf("hashlife needs a square world with a power of two side, not %vx%v", p.ImageWidth, p.ImageHeight)
+	}
+	if rule.States > 2 {
+		return errors.New("hashlife does not support Generations rules")
+	}
+	if topology != util.Torus {
+		return errors.New("hashlife only supports the torus topology")
+	}
+	if p.MaxPeriod > 0 || p.StopOnCycle {
+		// Batches skip over the turns in between, so there is no hash of each turn to compare.
+		return errors.New("hashlife runs turns in batches, so it cannot look for cycles; leave MaxPeriod at 0 and StopOnCycle unset")
+	}
+	return nil
+}
+
+// startHashlife loads the session's world into a new quadtree, keeping the batch size of any
+// quadtree it replaces.
+func (s *session) startHashlife() {
+	h := &hashlife{
+		nodes:   make(map[nodeKey]*node),
+		results: make(map[advanceKey]*node),
+		empty:   []*node{deadCell},
+	}
+	h.rule, _ = util.ParseRule(s.params.Rule)
+	h.world = h.importBoard(s.world, 0, 0, levelOf(s.world.Width))
+	if s.hashlife != nil {
+		h.step = s.hashlife.step
+	}
+	s.hashlife = h
+	s.worldTurn = s.turn
+}
+
+func levelOf(size int) int {
+	level := 0
+	for 1<<uint(level) < size {
+		level++
+	}
+	return level
+}
+
+// join returns the canonical node with the given children.
+func (h *hashlife) join(nw, ne, sw, se *node) *node {
+	key := nodeKey{nw, ne, sw, se}
+	if n, ok := h.nodes[key]; ok {
+		return n
+	}
+	n := &node{
+		nw: nw, ne: ne, sw: sw, se: se,
+		level:      nw.level + 1,
+		population: nw.population + ne.population + sw.population + se.population,
+	}
+	h.nodes[key] = n
+	return n
+}
+
+// empty returns the node of the given level with no alive cells.
+func (h *hashlife) emptyNode(level int) *node {
+	for len(h.empty) <= level {
+		e := h.empty[len(h.empty)-1]
+		h.empty = append(h.empty, h.join(e, e, e, e))
+	}
+	return h.empty[level]
+}
+
+// importBoard builds the node for the square of the board at x, y of the given level.
+func (h *hashlife) importBoard(board util.BitBoard, x, y, level int) *node {
+	if level == 0 {
+		if board.Alive(x, y) {
+			return aliveCell
+		}
+		return deadCell
+	}
+	if level == 6 && blockEmpty(board, x, y) {
+		return h.emptyNode(6)
+	}
+	half := 1 << uint(level-1)
+	return h.join(
+		h.importBoard(board, x, y, level-1), h.importBoard(board, x+half, y, level-1),
+		h.importBoard(board, x, y+half, level-1), h.importBoard(board, x+half, y+half, level-1))
+}
+
+// blockEmpty reports whether the 64x64 block of the board at x, y has no alive cells.
+func blockEmpty(board util.BitBoard, x, y int) bool {
+	for row := y; row < y+64; row++ {
+		if board.Rows[row][x/64] != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// exportBoard writes the alive cells of n, whose top left is at x, y, into board,
+// wrapping around its edges.
+func exportBoard(n *node, board util.BitBoard, x, y int) {
+	if n.population == 0 {
+		return
+	}
+	if n.level == 0 {
+		board.Set(x%board.Width, y%board.Height, true)
+		return
+	}
+	half := 1 << uint(n.level-1)
+	exportBoard(n.nw, board, x, y)
+	exportBoard(n.ne, board, x+half, y)
+	exportBoard(n.sw, board, x, y+half)
+	exportBoard(n.se, board, x+half, y+half)
+}
+
+// board returns the world of the given size the quadtree holds.
+func (h *hashlife) board(width, height int) util.BitBoard {
+	board := util.NewBitBoard(width, height)
+	exportBoard(h.world, board, h.offset, h.offset)
+	return board
+}
+
+// centre returns the middle half of n.
+func (h *hashlife) centre(n *node) *node {
+	return h.join(n.nw.se, n.ne.sw, n.sw.ne, n.se.nw)
+}
+
+// advance returns the centre of n, a node of level 2 or more, after 2^step turns,
+// where step is at most n.level-2.
+func (h *hashlife) advance(n *node, step int) *node {
+	if n.population == 0 && h.rule.Birth&1 == 0 {
+		return h.emptyNode(n.level - 1)
+	}
+	key := advanceKey{n, step}
+	if r, ok := h.results[key]; ok {
+		return r
+	}
+	var r *node
+	if n.level == 2 {
+		r = h.advanceLeaf(n)
+	} else {
+		// The nine overlapping squares of half the size that tile n.
+		n00, n01, n02 := n.nw, h.join(n.nw.ne, n.ne.nw, n.nw.se, n.ne.sw), n.ne
+		n10 := h.join(n.nw.sw, n.nw.se, n.sw.nw, n.sw.ne)
+		n11 := h.join(n.nw.se, n.ne.sw, n.sw.ne, n.se.nw)
+		n12 := h.join(n.ne.sw, n.ne.se, n.se.nw, n.se.ne)
+		n20, n21, n22 := n.sw, h.join(n.sw.ne, n.se.nw, n.sw.se, n.se.sw), n.se
+
+		// At full speed both halves of the time step advance; otherwise only the second does.
+		half := step
+		first := h.centre
+		if step == n.level-2 {
+			half = step - 1
+			first = func(m *node) *node { return h.advance(m, half) }
+		}
+		r00, r01, r02 := first(n00), first(n01), first(n02)
+		r10, r11, r12 := first(n10), first(n11), first(n12)
+		r20, r21, r22 := first(n20), first(n21), first(n22)
+		r = h.join(
+			h.advance(h.join(r00, r01, r10, r11), half), h.advance(h.join(r01, r02, r11, r12), half),
+			h.advance(h.join(r10, r11, r20, r21), half), h.advance(h.join(r11, r12, r21, r22), half))
+	}
+	h.results[key] = r
+	return r
+}
+
+// advanceLeaf returns the centre 2x2 cells of a 4x4 node after one turn.
+func (h *hashlife) advanceLeaf(n *node) *node {
+	var cells [4][4]bool
+	for i, quadrant := range [4]*node{n.nw, n.ne, n.sw, n.se} {
+		for j, cell := range [4]*node{quadrant.nw, quadrant.ne, quadrant.sw, quadrant.se} {
+			cells[i/2*2+j/2][i%2*2+j%2] = cell.population == 1
+		}
+	}
+	next := func(x, y int) *node {
+		neighbours := 0
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				if (dx != 0 || dy != 0) && cells[y+dy][x+dx] {
+					neighbours++
+				}
+			}
+		}
+		if h.rule.Next(cells[y][x], neighbours) {
+			return aliveCell
+		}
+		return deadCell
+	}
+	return h.join(next(1, 1), next(2, 1), next(1, 2), next(2, 2))
+}
+
+// hashlifeTurns advances the session's world by 2^step turns.
+func (s *session) hashlifeTurns(step int) {
+	h := s.hashlife
+	base := h.world.level
+	// Tile the world until the tiling is big enough to advance by 2^step turns and still
+	// have a whole copy of the world left in its centre.
+	level := step + 2
+	if level < base+1 {
+		level = base + 1
+	}
+	tiling := h.world
+	for tiling.level < level {
+		tiling = h.join(tiling, tiling, tiling, tiling)
+	}
+	result := h.advance(tiling, step)
+	for result.level > base {
+		result = result.nw
+	}
+	// The result starts a quarter of the way into the tiling, which is a whole number
+	// of copies of the world unless the tiling is only twice its size.
+	if level-2 < base {
+		h.offset = (h.offset + 1<<uint(level-2)) % (1 << uint(base))
+	}
+	h.world = result
+	s.turn += 1 << uint(step)
+
+	if len(h.nodes) > hashlifeMaxNodes {
+		// Start again from the current world to drop the nodes that are no longer needed.
+		s.world = h.board(s.params.ImageWidth, s.params.ImageHeight)
+		s.startHashlife()
+	}
+}
+
+// nextHashlifeStep picks log2 of the number of turns in the next batch, growing it while
+// batches finish quickly, like nextBatchSize does for the servers.
+func (s *session) nextHashlifeStep(lastBatch time.Duration, remainingTurns int) int {
+	h := s.hashlife
+	if lastBatch < batchTarget/2 {
+		h.step++
+	} else if lastBatch > batchTarget && h.step > 0 {
+		h.step--
+	}
+	for h.step > 0 && 1<<uint(h.step) > remainingTurns {
+		h.step--
+	}
+	return h.step
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// stepTorus advances a torus world by one turn with the kernel the servers run on strips.
+func stepTorus(board util.BitBoard, rule util.Rule) util.BitBoard {
+	width, height := board.Width, board.Height
+	rows := append(append(append([][]uint64{}, board.Rows[height-1]), board.Rows...), board.Rows[0])
+	next := util.NextRows(width, rule, util.Torus, rows, util.AllWords(height, util.WordsPerRow(width)), 2)
+	return util.BitBoard{Width: width, Height: height, AgePlanes: board.AgePlanes, Rows: next}
+}
+
+// TestHashlife checks that Hashlife agrees with the strips kernel after batches of turns of
+// every size it uses, including when it starts afresh after keeping too many nodes.
+func TestHashlife(t *testing.T) {
+	defer func(max int) { hashlifeMaxNodes = max }(hashlifeMaxNodes)
+	for _, ruleName := range []string{"B3/S23", "B36/S23", "B3678/S34678", "B2/S"} {
+		rule, err := util.ParseRule(ruleName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, size := range []int{2, 8, 32, 128} {
+			for _, maxNodes := range []int{4000000, 200} {
+				t.Run(fmt.Sprintf("%v-%dx%d-%d", rule, size, size, maxNodes), func(t *testing.T) {
+					hashlifeMaxNodes = maxNodes
+					rng := rand.New(rand.NewSource(int64(size)))
+					world := util.NewBitBoard(size, size)
+					for y := 0; y < size; y++ {
+						for x := 0; x < size; x++ {
+							world.Set(x, y, rng.Intn(3) == 0)
+						}
+					}
+
+					s := newSession("1")
+					s.params = Params{ImageWidth: size, ImageHeight: size, Rule: rule.String()}
+					s.world = world
+					s.startHashlife()
+					want := world
+					for _, step := range []int{0, 1, 0, 2, 3, 5, 1, 7} {
+						s.hashlifeTurns(step)
+						for i := 0; i < 1<<uint(step); i++ {
+							want = stepTorus(want, rule)
+						}
+						got := s.hashlife.board(size, size)
+						for y := 0; y < size; y++ {
+							for x := 0; x < size; x++ {
+								if got.Alive(x, y) != want.Alive(x, y) {
+									t.Fatalf("turn %v: cell (%v, %v) is alive %v, want %v", s.turn, x, y, got.Alive(x, y), want.Alive(x, y))
+								}
+							}
+						}
+					}
+				})
+			}
+		}
+	}
+}
+
+// TestCheckHashlife checks which simulations Hashlife refuses to run, including any that look
+// for cycles, which it would never find.
+func TestCheckHashlife(t *testing.T) {
+	tests := []struct {
+		p       Params
+		wantErr string
+	}{
+		{Params{ImageWidth: 64, ImageHeight: 64}, ""},
+		{Params{ImageWidth: 64, ImageHeight: 32}, "square world"},
+		{Params{ImageWidth: 48, ImageHeight: 48}, "power of two"},
+		{Params{ImageWidth: 64, ImageHeight: 64, Rule: "B2/S/C3"}, "Generations"},
+		{Params{ImageWidth: 64, ImageHeight: 64, Topology: "plane"}, "torus"},
+		{Params{ImageWidth: 64, ImageHeight: 64, MaxPeriod: 16}, "cannot look for cycles"},
+		{Params{ImageWidth: 64, ImageHeight: 64, StopOnCycle: true}, "cannot look for cycles"},
+	}
+	for _, test := range tests {
+		rule, err := util.ParseRule(test.p.Rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		topology, err := util.ParseTopology(test.p.Topology)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = checkHashlife(test.p, rule, topology)
+		if test.wantErr == "" && err != nil {
+			t.Errorf("%+v: %v", test.p, err)
+		} else if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+			t.Errorf("%+v: error %v, want one containing %q", test.p, err, test.wantErr)
+		}
+	}
+
+	// The broker refuses to load the world at all.
+	s := newSession("1")
+	p := Params{ImageWidth: 64, ImageHeight: 64, Algorithm: "hashlife", MaxPeriod: 16}
+	if err := s.initialise(util.NewBitBoard(64, 64), 0, p); err == nil {
+		t.Error("initialised a Hashlife simulation looking for cycles")
+	}
+}
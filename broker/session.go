@@ -0,0 +1,122 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// session is one simulation on the broker: its world, how far it has got, the strips it has
+// resident on the servers and the signals used to control it. Nothing about a simulation is
+// kept outside its session, so sessions do not interfere with one another.
+type session struct {
+	id       string
+	lastUsed time.Time // when a client last called for the session, guarded by the broker's sessionsMutex
+
+	evolveMutex sync.Mutex // held while the world, the turn or the strips are in use
+	world       util.BitBoard
+	turn        int
+	params      Params
+	topology    util.Topology
+
+	pauseMutex        sync.Mutex
+	paused            bool
+	quitHappened      bool
+	terminateHappened bool
+	resumeSignal      chan bool
+	quitSignal        chan bool
+	terminateSignal   chan bool
+	stepSignal        chan bool     // wakes a paused simulation to run the turns Advance asked for
+	stepBudget        int           // turns left to run while paused
+	stepDone          chan struct{} // closed once the turns Advance asked for have been run
+
+	simulationMutex   sync.Mutex
+	simulationRunning bool
+	simulationDone    chan struct{} // closed once the simulation has stopped
+	simulationResult  Response
+	simulationEnded   time.Time // when the simulation stopped, zero if it has not been run
+	detachSignal      chan struct{}
+
+	strips      []*strip
+	poolVersion int // the version of the pool the strips were shared out from
+	worldTurn   int // the turn world holds; the servers may be further ahead
+	edgeDepth   int // rows kept from each end of every strip, bounding the batch size
+	batchSize   int
+	serversLost bool // every server was lost and none registered in time to carry on
+
+	lostWorkersMutex sync.Mutex
+	lostWorkers      []string // servers lost since the client last asked
+
+	lastSync       time.Time // when the world was last brought back from the servers
+	lastCheckpoint time.Time
+	flips          flipsLog
+	cycles         cycleDetector
+	hashlife       *hashlife // nil unless the simulation is running with Hashlife
+}
+
+func newSession(id string) *session {
+	return &session{
+		id:              id,
+		resumeSignal:    make(chan bool),
+		quitSignal:      make(chan bool),
+		terminateSignal: make(chan bool),
+		stepSignal:      make(chan bool, 1),
+		batchSize:       1,
+	}
+}
+
+// isRunning reports whether the session's simulation is running.
+func (s *session) isRunning() bool {
+	s.simulationMutex.Lock()
+	defer s.simulationMutex.Unlock()
+	return s.simulationRunning
+}
+
+// idleSince returns when the session was last used by a client or its simulation stopped,
+// whichever was later. The broker's sessionsMutex must be held.
+func (s *session) idleSince() time.Time {
+	s.simulationMutex.Lock()
+	defer s.simulationMutex.Unlock()
+	if s.simulationEnded.After(s.lastUsed) {
+		return s.simulationEnded
+	}
+	return s.lastUsed
+}
+
+// signal sends on one of the session's signal channels, giving up if the simulation stops
+// before it is received.
+func (s *session) signal(c chan bool) {
+	s.simulationMutex.Lock()
+	running, done := s.simulationRunning, s.simulationDone
+	s.simulationMutex.Unlock()
+	if !running {
+		return
+	}
+	select {
+	case c <- true:
+	case <-done:
+	}
+}
+
+// spendSteps takes the turns just run from those Advance asked for, letting Advance return once
+// they have all been run. It is called with pauseMutex held.
+func (s *session) spendSteps(turns int) {
+	if s.stepDone == nil {
+		return
+	}
+	s.stepBudget -= turns
+	if s.stepBudget <= 0 {
+		s.finishSteps()
+	}
+}
+
+// finishSteps lets Advance return, whether or not all the turns it asked for have been run. It
+// is called with pauseMutex held.
+func (s *session) finishSteps() {
+	s.stepBudget = 0
+	if s.stepDone != nil {
+		close(s.stepDone)
+		s.stepDone = nil
+	}
+}
@@ -3,8 +3,11 @@ package main
 import "uk.ac.bris.cs/gameoflife/util"
 
 var (
-	CalculateNextStateHandler = "GOLOperations.CalculateNextState"
-	TerminateServerHandler    = "GOLOperations.Terminate"
+	LoadStripHandler       = "GOLOperations.LoadStrip"
+	StepHandler            = "GOLOperations.Step"
+	GetStripHandler        = "GOLOperations.GetStrip"
+	DropStripHandler       = "GOLOperations.DropStrip"
+	TerminateServerHandler = "GOLOperations.Terminate"
 
 	CurrentWorldStateHandler      = "Broker.CurrentWorldState"
 	InitialiseBoardAndTurnHandler = "Broker.InitialiseBoardAndTurn"
@@ -13,6 +16,13 @@ var (
 	QuitHandler                   = "Broker.Quit"
 	TerminateBrokerHandler        = "Broker.Terminate"
 	GOLHandler                    = "Broker.Evolve"
+	RegisterWorkerHandler         = "Broker.RegisterWorker"
+	DeregisterWorkerHandler       = "Broker.DeregisterWorker"
+	DetachHandler                 = "Broker.Detach"
+	FlipsHandler                  = "Broker.Flips"
+	AttachHandler                 = "Broker.Attach"
+	CloseSessionHandler           = "Broker.CloseSession"
+	AdvanceHandler                = "Broker.Advance"
 )
 
 type Params struct {
@@ -20,20 +30,32 @@ type Params struct {
 	Threads     int
 	ImageWidth  int
 	ImageHeight int
+	Rule        string // rule in B/S or B/S/C notation, empty for Conway's B3/S23
+	Topology    string // how the edges are joined: torus, plane, cylinder or klein, empty for torus
+	Algorithm   string // strips to share the world between the servers, or hashlife to run it on the broker
+	MaxPeriod   int    // longest cycle to look for, 0 to not look for cycles
+	StopOnCycle bool   // skip ahead to the last turn once a cycle is found
+	Stream      bool   // send the cells flipped each turn, for a window showing the board
 }
 
 type Response struct {
-	FinalBoard [][]byte
+	FinalBoard util.BitBoard
 	Turn       int
 	Paused     bool
 	Quit       bool
 	Terminated bool
+	Detached   bool
+	// WorkersLost is set if the simulation stopped because every server was lost.
+	WorkersLost bool
+	P           Params
 }
 
 type Request struct {
-	P            Params
-	World        [][]byte
-	ServerNumber int
+	P       Params
+	World   util.BitBoard
+	StartY  int
+	EndY    int
+	Session string // the broker session the request is for
 } //gameboard
 
 type EmptyResponse struct {
@@ -42,9 +64,26 @@ type EmptyResponse struct {
 type EmptyRequest struct {
 }
 
+type SessionRequest struct {
+	Session string
+}
+
+type SessionResponse struct {
+	Session string
+}
+
+// AdvanceRequest asks for a paused simulation to run the given number of turns and pause again.
+type AdvanceRequest struct {
+	Session string
+	Turns   int
+}
+
 type TickerResponse struct {
-	AliveCells []util.Cell
-	Turn       int
+	AliveCells  []util.Cell
+	Turn        int
+	LostWorkers []string
+	CycleTurn   int // the turn a cycle was detected at, reported once
+	CyclePeriod int
 }
 
 type KeyPressed struct {
@@ -52,7 +91,45 @@ type KeyPressed struct {
 }
 
 type ServerSliceResponse struct {
-	Slice [][]byte
+	Slice     [][]uint64
+	Unchanged bool // the strip is the same as when the broker last loaded or fetched it
+}
+
+type HaloRequest struct {
+	Session string
+	Above   [][]uint64
+	Below   [][]uint64
+	Turns   int
+	Depth   int
+	Flips   bool
+	// Hashes asks for a hash of the strip after each turn, for detecting cycles.
+	Hashes bool
+}
+
+type HaloResponse struct {
+	Top    [][]uint64
+	Bottom [][]uint64
+	Flips  [][]util.Cell
+	Hashes []uint64
+	// Unchanged is set instead of Top and Bottom if the strip did not change in any turn.
+	Unchanged bool
+}
+
+type FlipsRequest struct {
+	Session string
+	Turn    int
+}
+
+type TurnFlips struct {
+	Turn  int
+	Cells []util.Cell
+}
+
+type FlipsResponse struct {
+	Turns  []TurnFlips
+	Resync bool
+	World  util.BitBoard
+	Turn   int
 }
 
 type ServerAddress struct {
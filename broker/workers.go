@@ -0,0 +1,405 @@
+package main
+
+import (
+	"fmt"
+	"net/rpc"
+	"sync"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// worker is a registered GOLOperations server together with the connection used to reach it.
+type worker struct {
+	address string
+	client  *rpc.Client
+}
+
+// strip is a block of rows held resident on one server. The broker keeps copies of its
+// first and last edgeDepth rows to pass to the neighbouring strips as halo rows.
+type strip struct {
+	server *worker
+	startY int
+	endY   int
+	top    [][]uint64
+	bottom [][]uint64
+}
+
+var (
+	allServers    []*worker
+	workersMutex  sync.Mutex
+	poolVersion   int        // counts the changes to the servers or to the sessions sharing them
+	sharing       []*session // the sessions running on the servers, in the order they started
+	workerTimeout time.Duration
+	serverWait    time.Duration
+	syncInterval  time.Duration
+	maxBatch      int
+	batchTarget   time.Duration
+)
+
+// addWorker dials a server and adds it to the pool used by Evolve, replacing any
+// previous connection to the same address.
+func addWorker(address string) error {
+	// Servers only accept one connection at a time, so close any stale one before dialling.
+	removeWorker(address)
+	client, err := rpc.Dial("tcp", address)
+	if err != nil {
+		return err
+	}
+	workersMutex.Lock()
+	defer workersMutex.Unlock()
+	allServers = append(allServers, &worker{address: address, client: client})
+	poolVersion++
+	fmt.Printf("Server %v registered (%v servers)\n", address, len(allServers))
+	return nil
+}
+
+// removeWorker drops the server at an address from the pool and closes its connection.
+func removeWorker(address string) {
+	removeFromPool(func(w *worker) bool { return w.address == address })
+}
+
+// removeFromPool drops the first server that matches from the pool and closes its connection.
+func removeFromPool(match func(w *worker) bool) {
+	workersMutex.Lock()
+	defer workersMutex.Unlock()
+	for i, w := range allServers {
+		if match(w) {
+			w.client.Close()
+			allServers = append(allServers[:i], allServers[i+1:]...)
+			poolVersion++
+			fmt.Printf("Server %v deregistered (%v servers)\n", w.address, len(allServers))
+			return
+		}
+	}
+}
+
+// poolChangedSince reports whether servers or sessions have joined or left since the given
+// version of the pool, so that the servers need sharing out again.
+func poolChangedSince(version int) bool {
+	workersMutex.Lock()
+	defer workersMutex.Unlock()
+	return poolVersion != version
+}
+
+// joinPool adds a session to those sharing the servers.
+func joinPool(s *session) {
+	workersMutex.Lock()
+	defer workersMutex.Unlock()
+	sharing = append(sharing, s)
+	poolVersion++
+}
+
+// leavePool removes a session from those sharing the servers.
+func leavePool(s *session) {
+	workersMutex.Lock()
+	defer workersMutex.Unlock()
+	for i, t := range sharing {
+		if t == s {
+			sharing = append(sharing[:i], sharing[i+1:]...)
+			poolVersion++
+			return
+		}
+	}
+}
+
+// share returns a session's share of the servers. While there are enough to go round, each
+// session has an equal share to itself; otherwise the sessions are spread evenly over the
+// servers, which then each hold strips for several sessions. A session that is not sharing the
+// servers gets all of them.
+func share(servers []*worker, s *session) []*worker {
+	i, k, n := -1, len(sharing), len(servers)
+	for j, t := range sharing {
+		if t == s {
+			i = j
+		}
+	}
+	if i < 0 {
+		return servers
+	}
+	if n >= k {
+		return servers[i*n/k : (i+1)*n/k]
+	}
+	return servers[i%n : i%n+1]
+}
+
+// liveWorkers returns a snapshot of a session's share of the registered servers and the version
+// of the pool it was taken from, waiting until at least one server is available. If wait is not
+// zero, it gives up after waiting that long, returning no servers.
+func liveWorkers(s *session, wait time.Duration) ([]*worker, int) {
+	waiting := false
+	start := time.Now()
+	for {
+		workersMutex.Lock()
+		if len(allServers) > 0 {
+			servers := share(allServers, s)
+			workers := make([]*worker, len(servers))
+			copy(workers, servers)
+			version := poolVersion
+			workersMutex.Unlock()
+			return workers, version
+		}
+		workersMutex.Unlock()
+		if !waiting {
+			fmt.Println("No servers registered. Waiting for a server to register.")
+			waiting = true
+		}
+		if wait != 0 && time.Since(start) > wait {
+			return nil, 0
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// partitionRows splits height rows into at most n contiguous strips of nearly equal size.
+func partitionRows(height, n int) [][2]int {
+	if n > height {
+		n = height
+	}
+	rows := make([][2]int, n)
+	for i := range rows {
+		rows[i] = [2]int{i * height / n, (i + 1) * height / n}
+	}
+	return rows
+}
+
+// callWorker makes an RPC call to a server, giving up after workerTimeout.
+func callWorker(server *worker, method string, req interface{}, res interface{}) error {
+	call := server.client.Go(method, req, res, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		return call.Error
+	case <-time.After(workerTimeout):
+		return fmt.Errorf("no response after %v", workerTimeout)
+	}
+}
+
+// dropWorker removes a failed server from the pool and queues a notice for the client. Only the
+// failed connection is removed, so a server that has registered again since is kept.
+func (s *session) dropWorker(server *worker, err error) {
+	fmt.Printf("Server %v failed: %v\n", server.address, err)
+	removeFromPool(func(w *worker) bool { return w == server })
+	s.lostWorkersMutex.Lock()
+	s.lostWorkers = append(s.lostWorkers, server.address)
+	s.lostWorkersMutex.Unlock()
+}
+
+// forEachStrip runs f for every strip in parallel. Servers that fail are dropped from the
+// pool and false is returned.
+func (s *session) forEachStrip(f func(i int, st *strip) error) bool {
+	errs := make([]error, len(s.strips))
+	var stripsWg sync.WaitGroup
+	for i, st := range s.strips {
+		stripsWg.Add(1)
+		go func(i int, st *strip) {
+			defer stripsWg.Done()
+			errs[i] = f(i, st)
+		}(i, st)
+	}
+	stripsWg.Wait()
+
+	ok := true
+	for i, err := range errs {
+		if err != nil {
+			s.dropWorker(s.strips[i].server, err)
+			ok = false
+		}
+	}
+	return ok
+}
+
+// distributeWorld splits the world between the live servers and loads each strip onto its server.
+// If no server registers within serverWait, it stops the session at the last world it holds.
+func (s *session) distributeWorld() {
+	p := s.params
+	for {
+		workers, version := liveWorkers(s, serverWait)
+		s.releaseStrips(workers)
+		if len(workers) == 0 {
+			fmt.Printf("No server registered within %v, so session %v is stopping at turn %v\n", serverWait, s.id, s.worldTurn)
+			s.strips = nil
+			s.turn = s.worldTurn
+			s.serversLost = true
+			return
+		}
+		rows := partitionRows(p.ImageHeight, len(workers))
+		s.edgeDepth = maxBatch
+		if s.edgeDepth < 1 {
+			s.edgeDepth = 1
+		}
+		for _, r := range rows {
+			if r[1]-r[0] < s.edgeDepth {
+				s.edgeDepth = r[1] - r[0]
+			}
+		}
+		s.strips = make([]*strip, len(rows))
+		for i, r := range rows {
+			s.strips[i] = &strip{
+				server: workers[i],
+				startY: r[0],
+				endY:   r[1],
+				top:    s.world.Rows[r[0] : r[0]+s.edgeDepth],
+				bottom: s.world.Rows[r[1]-s.edgeDepth : r[1]],
+			}
+		}
+		s.poolVersion = version
+		s.turn = s.worldTurn
+		loaded := s.forEachStrip(func(i int, st *strip) error {
+			rows := util.BitBoard{Width: p.ImageWidth, Height: st.endY - st.startY, AgePlanes: s.world.AgePlanes, Rows: s.world.Rows[st.startY:st.endY]}
+			req := Request{P: p, World: rows, StartY: st.startY, EndY: st.endY, Session: s.id}
+			return callWorker(st.server, LoadStripHandler, req, new(EmptyResponse))
+		})
+		if loaded {
+			return
+		}
+	}
+}
+
+// releaseStrips tells the servers holding the session's strips, other than those to keep, that
+// they can drop them.
+func (s *session) releaseStrips(keep []*worker) {
+	for _, st := range s.strips {
+		kept := false
+		for _, w := range keep {
+			kept = kept || w == st.server
+		}
+		if !kept {
+			// A server that has failed has nothing left to drop, so errors are ignored.
+			callWorker(st.server, DropStripHandler, SessionRequest{Session: s.id}, new(EmptyResponse))
+		}
+	}
+}
+
+// rollback returns to the last world the broker holds after a server has been lost,
+// as that server's rows for any later turn are gone.
+func (s *session) rollback() {
+	if s.turn != s.worldTurn {
+		fmt.Printf("Rolling back from turn %v to turn %v\n", s.turn, s.worldTurn)
+	}
+	s.flips.rewind(s.worldTurn)
+	s.cycles.rewind(s.worldTurn)
+	s.distributeWorld()
+}
+
+// nextBatchSize picks how many turns to compute in the next batch. The size grows while batches
+// finish well inside batchTarget so that slow links are not bound by round-trip latency, and
+// shrinks when they take longer, so that the ticker and key presses are still served promptly.
+func (s *session) nextBatchSize(lastBatch time.Duration, remainingTurns int) int {
+	if lastBatch < batchTarget/2 {
+		s.batchSize *= 2
+	} else if lastBatch > batchTarget && s.batchSize > 1 {
+		s.batchSize /= 2
+	}
+	if s.batchSize > s.edgeDepth {
+		s.batchSize = s.edgeDepth
+	}
+	if s.batchSize < 1 {
+		s.batchSize = 1
+	}
+	if s.batchSize > remainingTurns {
+		return remainingTurns
+	}
+	return s.batchSize
+}
+
+// stepStrips advances every strip by the given number of turns. Each server is sent that
+// many halo rows from each of its neighbours.
+func (s *session) stepStrips(turns int) {
+	record := s.flips.isRecording()
+	hashes := s.detectingCycles()
+	n := len(s.strips)
+	results := make([]HaloResponse, n)
+	ok := s.forEachStrip(func(i int, st *strip) error {
+		req := HaloRequest{
+			Session: s.id,
+			Above:   s.haloAbove(i, turns),
+			Below:   s.haloBelow(i, turns),
+			Turns:   turns,
+			Depth:   s.edgeDepth,
+			Flips:   record,
+			Hashes:  hashes,
+		}
+		return callWorker(st.server, StepHandler, req, &results[i])
+	})
+	if !ok {
+		s.rollback()
+		return
+	}
+	for i, st := range s.strips {
+		if !results[i].Unchanged {
+			st.top = results[i].Top
+			st.bottom = results[i].Bottom
+		}
+	}
+	if record {
+		s.flips.log(s.turn, results, turns)
+	}
+	if hashes {
+		s.recordHashes(s.turn, results, turns)
+	}
+	s.turn += turns
+}
+
+// haloAbove returns the rows above strip i for a batch of the given number of turns.
+func (s *session) haloAbove(i, turns int) [][]uint64 {
+	n := len(s.strips)
+	above := s.strips[(i-1+n)%n].bottom
+	above = above[len(above)-turns:]
+	if i == 0 {
+		return s.topology.EdgeRows(s.params.ImageWidth, above)
+	}
+	return above
+}
+
+// haloBelow returns the rows below strip i for a batch of the given number of turns.
+func (s *session) haloBelow(i, turns int) [][]uint64 {
+	n := len(s.strips)
+	below := s.strips[(i+1)%n].top[:turns]
+	if i == n-1 {
+		return s.topology.EdgeRows(s.params.ImageWidth, below)
+	}
+	return below
+}
+
+// syncIfDue brings the world back from the servers when the sync interval has passed, so that
+// losing a server only loses the turns since then, whether or not a client is asking for them.
+func (s *session) syncIfDue() {
+	if s.hashlife != nil || time.Since(s.lastSync) < syncInterval {
+		return
+	}
+	s.syncWorld()
+}
+
+// syncWorld collects the resident strips, or reads the Hashlife quadtree, so that the world
+// holds the current turn.
+func (s *session) syncWorld() {
+	if s.worldTurn == s.turn {
+		return
+	}
+	if s.hashlife != nil {
+		s.world = s.hashlife.board(s.params.ImageWidth, s.params.ImageHeight)
+		s.worldTurn = s.turn
+		return
+	}
+	slices := make([]ServerSliceResponse, len(s.strips))
+	ok := s.forEachStrip(func(i int, st *strip) error {
+		return callWorker(st.server, GetStripHandler, SessionRequest{Session: s.id}, &slices[i])
+	})
+	if !ok {
+		s.rollback()
+		return
+	}
+	world := util.BitBoard{Width: s.params.ImageWidth, Height: s.params.ImageHeight, AgePlanes: s.world.AgePlanes}
+	for i, slice := range slices {
+		if slice.Unchanged {
+			// The server's rows are the same as when the world was last assembled.
+			st := s.strips[i]
+			world.Rows = append(world.Rows, s.world.Rows[st.startY:st.endY]...)
+		} else {
+			world.Rows = append(world.Rows, slice.Slice...)
+		}
+	}
+	s.world = world
+	s.worldTurn = s.turn
+	s.lastSync = time.Now()
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net"
+	"net/rpc"
+	"testing"
+)
+
+// pipeWorker returns a server at the given address whose connection goes nowhere.
+func pipeWorker(address string) *worker {
+	conn, _ := net.Pipe()
+	return &worker{address: address, client: rpc.NewClient(conn)}
+}
+
+// TestDropWorker checks that dropping a failed connection to a server leaves a connection the
+// server has registered since on the same address.
+func TestDropWorker(t *testing.T) {
+	defer func(servers []*worker) { allServers = servers }(allServers)
+	stale, fresh, other := pipeWorker("localhost:8050"), pipeWorker("localhost:8050"), pipeWorker("localhost:8051")
+	allServers = []*worker{fresh, other}
+
+	newSession("1").dropWorker(stale, nil)
+	if len(allServers) != 2 || allServers[0] != fresh || allServers[1] != other {
+		t.Fatalf("servers after dropping a stale connection are %v, want the fresh one kept", allServers)
+	}
+	newSession("1").dropWorker(fresh, nil)
+	if len(allServers) != 1 || allServers[0] != other {
+		t.Errorf("servers after dropping the fresh connection are %v, want only the other server", allServers)
+	}
+}
+
+// TestShare checks that the servers are shared out between any number of sessions: each has
+// servers of its own while there are enough, otherwise the sessions are spread evenly over them.
+func TestShare(t *testing.T) {
+	defer func(s []*session) { sharing = s }(sharing)
+	for n := 1; n <= 7; n++ {
+		servers := make([]*worker, n)
+		for i := range servers {
+			servers[i] = &worker{}
+		}
+		for k := 1; k <= 9; k++ {
+			sharing = nil
+			for i := 0; i < k; i++ {
+				sharing = append(sharing, newSession(string(rune('a'+i))))
+			}
+			sessionsOn := make(map[*worker]int)
+			fewest, most := n, 0
+			for _, s := range sharing {
+				got := share(servers, s)
+				if len(got) < fewest {
+					fewest = len(got)
+				}
+				if len(got) > most {
+					most = len(got)
+				}
+				for _, server := range got {
+					sessionsOn[server]++
+				}
+			}
+			if fewest == 0 || most-fewest > 1 {
+				t.Errorf("%v servers, %v sessions: shares of %v to %v servers", n, k, fewest, most)
+			}
+			for _, server := range servers {
+				want := 1
+				if k > n {
+					// Each server has the sessions i, i+n, i+2n and so on.
+					want = (k + n - 1 - indexOf(servers, server)) / n
+				}
+				if sessionsOn[server] != want {
+					t.Errorf("%v servers, %v sessions: server %v holds %v sessions, want %v", n, k, indexOf(servers, server), sessionsOn[server], want)
+				}
+			}
+			if got := share(servers, newSession("not sharing")); len(got) != n {
+				t.Errorf("%v servers: a session not sharing them has %v, want all of them", n, len(got))
+			}
+		}
+	}
+}
+
+func indexOf(servers []*worker, server *worker) int {
+	for i, w := range servers {
+		if w == server {
+			return i
+		}
+	}
+	return -1
+}
+
+// TestPartitionRows checks that the rows are split into contiguous strips of nearly equal size,
+// with no more strips than rows.
+func TestPartitionRows(t *testing.T) {
+	for height := 1; height <= 20; height++ {
+		for n := 1; n <= 25; n++ {
+			strips := partitionRows(height, n)
+			want := n
+			if n > height {
+				want = height
+			}
+			if len(strips) != want {
+				t.Errorf("%v rows in %v: %v strips, want %v", height, n, len(strips), want)
+				continue
+			}
+			next, fewest, most := 0, height, 0
+			for _, strip := range strips {
+				if strip[0] != next {
+					t.Errorf("%v rows in %v: strips %v are not contiguous", height, n, strips)
+					break
+				}
+				size := strip[1] - strip[0]
+				if size < fewest {
+					fewest = size
+				}
+				if size > most {
+					most = size
+				}
+				next = strip[1]
+			}
+			if next != height || fewest == 0 || most-fewest > 1 {
+				t.Errorf("%v rows in %v: strips %v", height, n, strips)
+			}
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package gol
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// Plaintext (.cells) files draw a pattern with a line of '.' for dead and 'O' for alive cells
+// per row, after comment lines starting with '!'. Life 1.06 (.lif) files list the x and y of
+// each alive cell on a line of its own after a "#Life 1.06" line. Both only have two states, so
+// dying cells of a Generations rule are written as dead.
+
+// readPlaintext reads a plaintext pattern for a board of the given size.
+func readPlaintext(r *bufio.Reader, boardWidth, boardHeight int) (*pattern, error) {
+	var rows []string
+	for line := 1; ; line++ {
+		text, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		text = strings.TrimRight(text, "\r\n")
+		if !strings.HasPrefix(text, "!") {
+			if i := strings.IndexFunc(text, func(c rune) bool { return c != '.' && c != 'O' && c != '*' }); i >= 0 {
+				return nil, fmt.Errorf("line %v: %q is not a cell", line, text[i])
+			}
+			// Rows past the board are only kept track of if they are empty, as they may end the file.
+			switch {
+			case len(text) > boardWidth:
+				return nil, patternTooLarge(fmt.Sprintf("a pattern %v cells wide", len(text)), boardWidth, boardHeight)
+			case len(rows) < boardHeight:
+				rows = append(rows, text)
+			case text != "":
+				return nil, patternTooLarge(fmt.Sprintf("a pattern of more than %v rows", boardHeight), boardWidth, boardHeight)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	for len(rows) > 0 && rows[len(rows)-1] == "" {
+		rows = rows[:len(rows)-1]
+	}
+
+	pat := &pattern{height: len(rows)}
+	for _, row := range rows {
+		if len(row) > pat.width {
+			pat.width = len(row)
+		}
+	}
+	if err := pat.allocate(boardWidth, boardHeight); err != nil {
+		return nil, err
+	}
+	for y, row := range rows {
+		for x, c := range row {
+			if c != '.' {
+				pat.states[y][x] = 1
+			}
+		}
+	}
+	return pat, nil
+}
+
+// readLife106 reads a Life 1.06 pattern for a board of the given size.
+func readLife106(r *bufio.Reader, boardWidth, boardHeight int) (*pattern, error) {
+	var cells []util.Cell
+	for line := 1; ; line++ {
+		text, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		text = strings.TrimSpace(text)
+		switch {
+		case line == 1 && !strings.HasPrefix(text, "#Life 1.06"):
+			return nil, fmt.Errorf("line 1: want #Life 1.06, not %q", text)
+		case text == "" || strings.HasPrefix(text, "#"):
+		default:
+			fields := strings.Fields(text)
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("line %v: want x and y, not %q", line, text)
+			}
+			x, errX := strconv.Atoi(fields[0])
+			y, errY := strconv.Atoi(fields[1])
+			if errX != nil || errY != nil {
+				return nil, fmt.Errorf("line %v: %q is not a pair of numbers", line, text)
+			}
+			cells = append(cells, util.Cell{X: x, Y: y})
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+
+	// The origin is included so an empty pattern, or one off to one side, is placed relative to it.
+	minX, minY, maxX, maxY := 0, 0, 0, 0
+	for _, c := range cells {
+		minX, minY = minInt(minX, c.X), minInt(minY, c.Y)
+		maxX, maxY = maxInt(maxX, c.X), maxInt(maxY, c.Y)
+	}
+	// The spans are compared unsigned, as cells far apart may be further than an int can hold.
+	if uint64(maxX)-uint64(minX) >= uint64(boardWidth) || uint64(maxY)-uint64(minY) >= uint64(boardHeight) {
+		return nil, patternTooLarge(fmt.Sprintf("a pattern from %v,%v to %v,%v", minX, minY, maxX, maxY), boardWidth, boardHeight)
+	}
+	pat := &pattern{
+		width:     maxX - minX + 1,
+		height:    maxY - minY + 1,
+		hasOrigin: true,
+		originX:   -minX,
+		originY:   -minY,
+	}
+	if err := pat.allocate(boardWidth, boardHeight); err != nil {
+		return nil, err
+	}
+	for _, c := range cells {
+		pat.states[c.Y-minY][c.X-minX] = 1
+	}
+	return pat, nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// writePlaintext writes a board of cell states as a plaintext pattern of the whole board.
+func writePlaintext(w io.Writer, width, height int, rule util.Rule, state func(x, y int) int) error {
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "!Name: %vx%v %v\n", width, height, rule)
+	row := make([]byte, width)
+	for y := 0; y < height; y++ {
+		for x := range row {
+			row[x] = '.'
+			if state(x, y) == 1 {
+				row[x] = 'O'
+			}
+		}
+		out.Write(row)
+		out.WriteString("\n")
+	}
+	return out.Flush()
+}
+
+// writeLife106 writes the alive cells of a board as Life 1.06, with the same coordinates as on
+// the board.
+func writeLife106(w io.Writer, width, height int, rule util.Rule, state func(x, y int) int) error {
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "#Life 1.06\n")
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if state(x, y) == 1 {
+				fmt.Fprintf(out, "%v %v\n", x, y)
+			}
+		}
+	}
+	return out.Flush()
+}
@@ -0,0 +1,168 @@
+package gol
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// aliveOnly returns a board with every dying cell dead, as the two-state formats write it.
+func aliveOnly(board [][]int) [][]int {
+	alive := make([][]int, len(board))
+	for y, row := range board {
+		alive[y] = make([]int, len(row))
+		for x, state := range row {
+			if state == 1 {
+				alive[y][x] = 1
+			}
+		}
+	}
+	return alive
+}
+
+// TestPlaintextRoundTrip writes boards as plaintext and reads them back, with dying cells of a
+// Generations rule written as dead.
+func TestPlaintextRoundTrip(t *testing.T) {
+	for _, ruleName := range []string{"B3/S23", "B2/S345/C4"} {
+		rule, err := util.ParseRule(ruleName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, size := range [][2]int{{1, 1}, {3, 3}, {100, 7}} {
+			width, height := size[0], size[1]
+			t.Run(fmt.Sprintf("%v-%dx%d", rule, width, height), func(t *testing.T) {
+				board := randomStates(int64(width*height), width, height, rule.States)
+				var out bytes.Buffer
+				err := writePlaintext(&out, width, height, rule, func(x, y int) int { return board[y][x] })
+				if err != nil {
+					t.Fatal(err)
+				}
+				pat, err := readPlaintext(bufio.NewReader(&out), width, height)
+				if err != nil {
+					t.Fatalf("reading back %q: %v", out.String(), err)
+				}
+				if diff := statesDiffer(pat, aliveOnly(board)); diff != "" {
+					t.Errorf("%v in %q", diff, out.String())
+				}
+			})
+		}
+	}
+}
+
+// TestLife106RoundTrip writes boards as Life 1.06 and reads them back. Only the alive cells are
+// listed, so the pattern read back is placed by its origin, which is the top left of the board.
+func TestLife106RoundTrip(t *testing.T) {
+	for _, ruleName := range []string{"B3/S23", "B2/S345/C4"} {
+		rule, err := util.ParseRule(ruleName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, size := range [][2]int{{1, 1}, {3, 3}, {100, 7}} {
+			width, height := size[0], size[1]
+			t.Run(fmt.Sprintf("%v-%dx%d", rule, width, height), func(t *testing.T) {
+				board := aliveOnly(randomStates(int64(width*height), width, height, rule.States))
+				var out bytes.Buffer
+				err := writeLife106(&out, width, height, rule, func(x, y int) int { return board[y][x] })
+				if err != nil {
+					t.Fatal(err)
+				}
+				pat, err := readLife106(bufio.NewReader(&out), width, height)
+				if err != nil {
+					t.Fatalf("reading back %q: %v", out.String(), err)
+				}
+				if !pat.hasOrigin || pat.originX != 0 || pat.originY != 0 {
+					t.Errorf("origin %v,%v, want 0,0", pat.originX, pat.originY)
+				}
+				for y := range board {
+					for x := range board[y] {
+						got := 0
+						if y < pat.height && x < pat.width {
+							got = pat.states[y][x]
+						}
+						if got != board[y][x] {
+							t.Fatalf("cell %v,%v has state %v, want %v in %q", x, y, got, board[y][x], out.String())
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestReadPlaintext reads plaintext with comments, '*' for alive cells and short rows.
+func TestReadPlaintext(t *testing.T) {
+	pat, err := readPlaintext(bufio.NewReader(strings.NewReader("!Name: Glider\n!\n.O\n..*\r\nOOO\n\n")), 16, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := statesDiffer(pat, [][]int{{0, 1, 0}, {0, 0, 1}, {1, 1, 1}}); diff != "" {
+		t.Error(diff)
+	}
+	_, err = readPlaintext(bufio.NewReader(strings.NewReader(".O\n.x\n")), 16, 16)
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("error %v, want one for line 2", err)
+	}
+}
+
+// TestReadLife106 reads Life 1.06 with negative coordinates, which place the origin inside the
+// pattern, and rejects malformed files.
+func TestReadLife106(t *testing.T) {
+	pat, err := readLife106(bufio.NewReader(strings.NewReader("#Life 1.06\n#D glider\n0 -1\n1 0\n-1 1\n0 1\n1 1\n")), 16, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pat.originX != 1 || pat.originY != 1 {
+		t.Errorf("origin %v,%v, want 1,1", pat.originX, pat.originY)
+	}
+	if diff := statesDiffer(pat, [][]int{{0, 1, 0}, {0, 0, 1}, {1, 1, 1}}); diff != "" {
+		t.Error(diff)
+	}
+
+	for _, test := range []struct{ data, wantErr string }{
+		{"0 0\n", "want #Life 1.06"},
+		{"#Life 1.06\n0\n", "line 2: want x and y"},
+		{"#Life 1.06\n0 0\na b\n", `line 3: "a b" is not a pair of numbers`},
+	} {
+		_, err := readLife106(bufio.NewReader(strings.NewReader(test.data)), 16, 16)
+		if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("reading %q: error %v, want one containing %q", test.data, err, test.wantErr)
+		}
+	}
+}
+
+// TestPatternTooLarge checks that plaintext and Life 1.06 patterns larger than the board are a
+// DimensionsError, found without making room for them, including cells further apart than an
+// int can hold and blank lines past the bottom of the board.
+func TestPatternTooLarge(t *testing.T) {
+	tests := []struct {
+		name, data string
+		fits       bool
+	}{
+		{"far.lif", "#Life 1.06\n0 0\n-100000 100000\n", false},
+		{"wide.lif", "#Life 1.06\n0 0\n16 0\n", false},
+		{"edges.lif", "#Life 1.06\n-9223372036854775808 0\n9223372036854775807 0\n", false},
+		{"fits.lif", "#Life 1.06\n-8 -8\n7 7\n", true},
+		{"wide.cells", strings.Repeat(".", 17) + "\n", false},
+		{"tall.cells", strings.Repeat("O\n", 17), false},
+		{"blank.cells", strings.Repeat("O\n", 16) + strings.Repeat("\n", 100), true},
+	}
+	for _, test := range tests {
+		path := writeTemp(t, test.name, []byte(test.data))
+		p := Params{ImageWidth: 16, ImageHeight: 16, Offset: &util.Cell{X: 8, Y: 8}}
+		if !strings.HasSuffix(test.name, ".lif") {
+			p.Offset = nil
+		}
+		err := readPattern(path, p, func(uint8) {})
+		var dimensionsErr *DimensionsError
+		if test.fits && err != nil {
+			t.Errorf("reading %v: %v", test.name, err)
+		} else if !test.fits && !errors.As(err, &dimensionsErr) {
+			t.Errorf("reading %v: error %v, want a DimensionsError", test.name, err)
+		}
+	}
+}
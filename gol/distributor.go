@@ -1,251 +1,441 @@
 package gol
 
 import (
-	"flag"
+	"context"
 	"fmt"
-	"log"
-	"net/rpc"
-	"strconv"
+	"path/filepath"
 	"sync"
 	"time"
+
+	"uk.ac.bris.cs/gameoflife/util"
 )
 
 type distributorChannels struct {
 	events     chan<- Event
 	ioCommand  chan<- ioCommand
-	ioIdle     <-chan bool
+	ioIdle     <-chan error
 	ioFilename chan<- string
 	ioOutput   chan<- uint8
 	ioInput    <-chan uint8
+	ioInputErr <-chan error
 }
 
-var (
-	closeTickerRoutine = make(chan bool)
-	ensureOneTestMutex sync.Mutex
-	keyPressMutex      sync.Mutex
-	wg                 sync.WaitGroup
-)
+// session is the state of one call to Run. Runs share nothing, so any number of them can go
+// on at once.
+type session struct {
+	ctx           context.Context
+	c             distributorChannels
+	engine        Engine
+	keyPressMutex sync.Mutex     // held while a key press is being handled
+	keyErr        error          // why the key press goroutine stopped the simulation, if it did
+	done          chan struct{}  // closed when the simulation is over, to stop the goroutines below
+	wg            sync.WaitGroup // the key press, ticker and cancellation goroutines
+	outputs       outputNames    // the names the run's images are written under
+}
+
+func newSession(ctx context.Context, c distributorChannels, engine Engine) *session {
+	return &session{
+		ctx:    ctx,
+		c:      c,
+		engine: engine,
+		done:   make(chan struct{}),
+	}
+}
 
-func makeCall(broker *rpc.Client, c distributorChannels, p Params, world [][]byte, keyPresses <-chan rune) *Response {
-	request := Request{P: p, World: world}
-	err1 := broker.Call(InitialiseBoardAndTurnHandler, request, new(EmptyResponse))
-	if err1 != nil {
-		panic(err1)
+// makeCall starts the simulation on the engine, or attaches to the one the broker is running,
+// and waits for it to finish. The streamer is only started if p.Stream is set, and is returned
+// whenever it was, even with an error, so that it can be stopped.
+func (s *session) makeCall(p *Params, world util.BitBoard, keyPresses <-chan rune) (*Response, *flipStreamer, error) {
+	c, engine := s.c, s.engine
+	if !p.Attach {
+		err := engine.Init(*p, world)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
 
-	initialBoardRequest := new(EmptyRequest)
-	initialBoardResponse := new(Response)
-	err := broker.Call(CurrentWorldStateHandler, initialBoardRequest, initialBoardResponse)
+	initialBoardResponse, err := engine.State()
 	if err != nil {
-		panic(err)
+		return nil, nil, err
+	}
+	if p.Attach {
+		// Carry on from where the broker has got to; RunContext has taken p from it.
+		world = initialBoardResponse.FinalBoard
 	}
 
-	c.events <- StateChange{0, Executing}
+	var streamer *flipStreamer
+	if p.Stream {
+		streamer = startFlipStreamer(engine, c, initialBoardResponse.FinalBoard, initialBoardResponse.Turn)
+	}
+	c.events <- StateChange{initialBoardResponse.Turn, Executing}
+
+	s.wg.Add(3)
+	go s.handleKeys(p, keyPresses, streamer)
+	go s.reportAliveCells()
+	go s.quitOnCancel()
+	finalStateResponse, err := engine.Evolve(*p, world)
+	return finalStateResponse, streamer, err
+}
 
+// handleKeys acts on key presses until the simulation is over, or until a key press ends it.
+// If acting on one fails, the simulation is quit and the error kept for distributor to return.
+// Digits typed before 'n' give the number of turns to advance by.
+func (s *session) handleKeys(p *Params, keyPresses <-chan rune, streamer *flipStreamer) {
+	defer s.wg.Done()
+	c, engine := s.c, s.engine
+	fail := func(err error) {
+		s.keyErr = err
+		engine.Quit()
+	}
 	paused := false
-	go func() {
-		for {
-			select {
-			case key := <-keyPresses:
-				switch key {
-				case 's':
-					keyPressMutex.Lock()
-					req := new(EmptyRequest)
-					currentWorldStateResponse := new(Response)
-					err := broker.Call(CurrentWorldStateHandler, req, currentWorldStateResponse)
-					if err != nil {
-						panic(err)
+	count := 0
+	for {
+		select {
+		case <-s.done:
+			return
+		case key := <-keyPresses:
+			if key >= '0' && key <= '9' {
+				count = count*10 + int(key-'0')
+				continue
+			}
+			turns := count
+			count = 0
+			switch key {
+			case 's', 'i':
+				// 'i' saves a png whatever the output format
+				command := ioOutput
+				if key == 'i' {
+					command = ioOutputPng
+				}
+				s.keyPressMutex.Lock()
+				currentWorldStateResponse, err := engine.State()
+				if err != nil {
+					s.keyPressMutex.Unlock()
+					fail(err)
+					return
+				}
+				filename := s.outputs.name(*p, currentWorldStateResponse.Turn)
+				err = saveImageWith(*p, c, currentWorldStateResponse.FinalBoard, filename, command)
+				if err == nil {
+					err = waitForIo(c)
+				}
+				s.keyPressMutex.Unlock()
+				if err != nil {
+					fail(err)
+					return
+				}
+				c.events <- ImageOutputComplete{currentWorldStateResponse.Turn, filename}
+			case 'q':
+				err := engine.Quit()
+				if err != nil {
+					s.keyErr = err
+				}
+				return
+			case 'd':
+				// leaves the simulation running on the broker so a client can attach to it later
+				err := engine.Detach()
+				if err != nil {
+					fmt.Println(err)
+					continue
+				}
+				return
+			case 'k':
+				// outputs final pgm image and shuts both client and server
+				_, err := engine.State()
+				if err != nil {
+					fail(err)
+					return
+				}
+				err = engine.Terminate()
+				if err != nil {
+					fmt.Println(err)
+					continue
+				}
+				return
+			case 'p':
+				currentWorldStateResponse, err := engine.State()
+				if err != nil {
+					fail(err)
+					return
+				}
+				paused = currentWorldStateResponse.Paused
+				if !paused {
+					err = engine.Pause()
+					if err == nil {
+						currentWorldStateResponse, err = engine.State()
 					}
-					filename := fmt.Sprintf("%dx%dx%d", p.ImageWidth, p.ImageHeight, currentWorldStateResponse.Turn)
-					saveImage(p, c, currentWorldStateResponse.FinalBoard, filename)
-					c.ioCommand <- ioCheckIdle
-					<-c.ioIdle
-					c.events <- ImageOutputComplete{currentWorldStateResponse.Turn, filename}
-					keyPressMutex.Unlock()
-				case 'q':
-					req := new(EmptyRequest)
-					res := new(EmptyResponse)
-					err := broker.Call(QuitHandler, req, res)
 					if err != nil {
-						panic(err)
+						fail(err)
+						return
 					}
-					return
-				case 'k':
-					// outputs final pgm image and shuts both client and server
-					req := new(EmptyRequest)
-					currentWorldStateResponse := new(Response)
-					err := broker.Call(CurrentWorldStateHandler, req, currentWorldStateResponse)
+					c.events <- StateChange{currentWorldStateResponse.Turn, Paused}
+					fmt.Println(currentWorldStateResponse.Turn)
+				} else {
+					c.events <- StateChange{currentWorldStateResponse.Turn, Executing}
+					fmt.Println("Continuing")
+					err = engine.Pause()
 					if err != nil {
-						panic(err)
+						fail(err)
+						return
 					}
-					req = new(EmptyRequest)
-					res := new(EmptyResponse)
-					broker.Call(TerminateBrokerHandler, req, res)
+				}
+			case 'n':
+				// advances a paused simulation by one turn, or by the number typed before it
+				if turns == 0 {
+					turns = 1
+				}
+				currentWorldStateResponse, err := engine.State()
+				if err != nil {
+					fail(err)
 					return
-				case 'p':
-					req := new(EmptyRequest)
-					res := new(EmptyResponse)
-					currentWorldStateResponse := new(Response)
-					err2 := broker.Call(CurrentWorldStateHandler, req, currentWorldStateResponse)
-					if err2 != nil {
-						panic(err2)
-					}
-					paused = currentWorldStateResponse.Paused
-					if !paused {
-						err := broker.Call(PauseHandler, req, res)
-						if err != nil {
-							panic(err)
-						}
-						err2 := broker.Call(CurrentWorldStateHandler, req, currentWorldStateResponse)
-						if err2 != nil {
-							panic(err2)
-						}
-						c.events <- StateChange{currentWorldStateResponse.Turn, Paused}
-						fmt.Println(currentWorldStateResponse.Turn)
-					} else {
-						c.events <- StateChange{currentWorldStateResponse.Turn, Executing}
-						fmt.Println("Continuing")
-						err := broker.Call(PauseHandler, req, res)
-						if err != nil {
-							panic(err)
-						}
-					}
+				}
+				if !currentWorldStateResponse.Paused {
+					fmt.Println("Pause with p before advancing with n")
+					continue
+				}
+				c.events <- StateChange{currentWorldStateResponse.Turn, Executing}
+				advanceResponse, err := engine.Advance(turns)
+				if err != nil {
+					fmt.Println(err)
+					c.events <- StateChange{currentWorldStateResponse.Turn, Paused}
+					continue
+				}
+				if advanceResponse.Turn < p.Turns {
+					// Show every turn run before saying the simulation is paused again.
+					streamer.waitUntil(advanceResponse.Turn)
+					c.events <- StateChange{advanceResponse.Turn, Paused}
+					fmt.Println(advanceResponse.Turn)
 				}
 			}
 		}
-	}()
-
-	go getCurrentAliveCells(c, p, world, broker)
-	finalStateRequest := Request{P: p, World: world}
-	finalStateResponse := new(Response)
-	err2 := broker.Call(GOLHandler, finalStateRequest, finalStateResponse)
-
-	if err2 != nil {
-		panic(err2)
 	}
-	return finalStateResponse
 }
 
-func getCurrentAliveCells(c distributorChannels, p Params, world [][]byte, broker *rpc.Client) {
+// reportAliveCells sends the number of alive cells every two seconds until the simulation is over.
+func (s *session) reportAliveCells() {
+	defer s.wg.Done()
+	c, engine := s.c, s.engine
 	ticker := time.NewTicker(2 * time.Second)
 	defer ticker.Stop()
 	for {
 		select {
 		case <-ticker.C:
 			// Process the current state
-			res := new(TickerResponse)
-			req := Request{P: p, World: world}
-			broker.Call(ReportAliveCellsHandler, req, res)
+			res, err := engine.AliveCells()
+			if err != nil {
+				continue
+			}
+			reportLostWorkers(c, res)
 			AliveCellsCountEvent := AliveCellsCount{res.Turn, len(res.AliveCells)}
 			c.events <- AliveCellsCountEvent
-		case <-closeTickerRoutine:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// quitOnCancel quits the simulation if the context is cancelled before it is over. Quitting is
+// retried until Evolve returns, in case the simulation had not quite started the first time.
+func (s *session) quitOnCancel() {
+	defer s.wg.Done()
+	select {
+	case <-s.ctx.Done():
+	case <-s.done:
+		return
+	}
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		s.engine.Quit()
+		select {
+		case <-ticker.C:
+		case <-s.done:
 			return
 		}
 	}
 }
 
-func createInitialBoard(p Params, c distributorChannels) [][]byte {
-	// Create a 2D slice to store the world.
-	world := make([][]byte, p.ImageHeight)
-	for i := range world {
-		world[i] = make([]byte, p.ImageWidth)
+// stop stops the goroutines makeCall started, so that key presses after the simulation has
+// ended are left for whoever reads keyPresses next.
+func (s *session) stop() {
+	close(s.done)
+	s.wg.Wait()
+}
+
+// reportLostWorkers forwards any server failures the broker has recovered from,
+// and any cycle it has found.
+func reportLostWorkers(c distributorChannels, res *TickerResponse) {
+	for _, address := range res.LostWorkers {
+		c.events <- WorkerLost{res.Turn, address}
+	}
+	if res.CyclePeriod != 0 {
+		c.events <- CycleDetected{res.CycleTurn, res.CyclePeriod}
 	}
+}
+
+var (
+	outputNamesMutex sync.Mutex
+	outputNamesTaken = map[string]bool{} // the images written by the runs going on, without extensions
+)
 
-	// Request the filename and read the image.
-	filename := strconv.Itoa(p.ImageWidth) + "x" + strconv.Itoa(p.ImageHeight)
-	c.ioCommand <- ioInput
-	c.ioFilename <- filename
+// outputNames hands out the names a run writes its images under: WxHxT, or WxHxT-2, WxHxT-3 and
+// so on if another run going on in this process has already written an image of that name to
+// the same directory. The names are kept until the run releases them.
+type outputNames struct {
+	names map[string]string // the name given to each image the run has written, by its unsuffixed path
+}
 
-	// Populate the world array from the input.
-	for y := 0; y < p.ImageHeight; y++ {
-		for x := 0; x < p.ImageWidth; x++ {
-			world[y][x] = <-c.ioInput
-		}
+// name returns the name to write the image of the given turn under, without its extension.
+func (o *outputNames) name(p Params, turn int) string {
+	dir, err := filepath.Abs(outputDir(p))
+	if err != nil {
+		dir = filepath.Clean(outputDir(p))
+	}
+	base := fmt.Sprintf("%dx%dx%d", p.ImageWidth, p.ImageHeight, turn)
+	outputNamesMutex.Lock()
+	defer outputNamesMutex.Unlock()
+	if name, ok := o.names[filepath.Join(dir, base)]; ok {
+		// The run is saving the same turn again, perhaps in another format.
+		return name
+	}
+	name := base
+	for n := 2; outputNamesTaken[filepath.Join(dir, name)]; n++ {
+		name = fmt.Sprintf("%v-%v", base, n)
 	}
-	return world
+	outputNamesTaken[filepath.Join(dir, name)] = true
+	if o.names == nil {
+		o.names = make(map[string]string)
+	}
+	o.names[filepath.Join(dir, base)] = name
+	return name
 }
 
-func saveImage(p Params, c distributorChannels, world [][]byte, filename string) {
-	c.ioCommand <- ioOutput
-	c.ioFilename <- filename
-	for y := 0; y < p.ImageHeight; y++ {
-		for x := 0; x < p.ImageWidth; x++ {
-			c.ioOutput <- world[y][x]
-		}
+// release lets other runs write images under the names this run has taken.
+func (o *outputNames) release() {
+	outputNamesMutex.Lock()
+	defer outputNamesMutex.Unlock()
+	for base, name := range o.names {
+		delete(outputNamesTaken, filepath.Join(filepath.Dir(base), name))
 	}
+	o.names = nil
+}
+
+// waitForIo waits until the io goroutine has finished any output, returning the error it failed
+// with, if it did.
+func waitForIo(c distributorChannels) error {
+	c.ioCommand <- ioCheckIdle
+	return <-c.ioIdle
 }
 
-func distributor(p Params, keyPresses <-chan rune, c distributorChannels) {
-	ensureOneTestMutex.Lock()
-	defer ensureOneTestMutex.Unlock()
-	wg.Wait()
-	world := createInitialBoard(p, c)
+// paramsRule parses the rule in p. RunContext has already checked it, so an error here means p
+// was changed since.
+func paramsRule(p Params) (util.Rule, error) {
+	return util.ParseRule(p.Rule)
+}
+
+// distributor runs the simulation and reports it through c.events, which it closes when it
+// returns, along with the io goroutine's command channel.
+func distributor(ctx context.Context, p Params, keyPresses <-chan rune, c distributorChannels) error {
+	defer close(c.events)
+	defer close(c.ioCommand)
+
+	var world util.BitBoard
+	if !p.Attach {
+		var err error
+		world, err = createInitialBoard(p, c)
+		if err != nil {
+			return err
+		}
+	}
 
 	// client side code
-	var brokerAddress string
-	if flag.Lookup("broker") == nil {
-		brokerPtr := flag.String("broker", "localhost:8030", "IP:port string to connect to as broker")
-		flag.Parse()
-		brokerAddress = *brokerPtr
-	} else {
-		brokerAddress = flag.Lookup("broker").Value.String()
+	engine, err := newEngine(ctx, p)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return err
 	}
+	defer engine.Close()
 
-	broker, err := rpc.Dial("tcp", brokerAddress)
-	wg.Add(1)
+	s := newSession(ctx, c, engine)
+	// The names are released before events is closed, so the next run can have them straight away.
+	defer s.outputs.release()
+	response, streamer, err := s.makeCall(&p, world, keyPresses)
+	s.stop()
+	// Normally it has already been stopped at the final turn.
+	defer streamer.stopAt(-1)
+	if err == nil {
+		err = s.keyErr
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
 	if err != nil {
-		log.Fatal("dialing:", err)
-	}
-	defer func() {
-		broker.Close()
-		wg.Done()
-	}()
-
-	response := makeCall(broker, c, p, world, keyPresses)
-
-	if response.Quit || response.Terminated {
-		req := new(EmptyRequest)
-		res := new(Response)
-		broker.Call(CurrentWorldStateHandler, req, res)
-		filename := fmt.Sprintf("%dx%dx%d", p.ImageWidth, p.ImageHeight, res.Turn)
-		saveImage(p, c, res.FinalBoard, filename)
-		c.ioCommand <- ioCheckIdle
-		<-c.ioIdle
+		return err
+	}
+
+	if response.Detached {
+		streamer.stopAt(-1)
+		fmt.Println("Detached from the broker. Attach again with -attach, and with -session if other simulations are running")
+		c.events <- StateChange{response.Turn, Quitting}
+		return nil
+	}
+
+	if response.Quit || response.Terminated || response.WorkersLost {
+		res, err := engine.State()
+		if err != nil {
+			return err
+		}
+		streamer.stopAt(res.Turn)
+		filename := s.outputs.name(p, res.Turn)
+		if err := saveImage(p, c, res.FinalBoard, filename); err != nil {
+			return err
+		}
+		if err := waitForIo(c); err != nil {
+			return err
+		}
 		c.events <- ImageOutputComplete{res.Turn, filename}
+		if response.WorkersLost {
+			return &WorkerLostError{Turn: res.Turn}
+		}
 		c.events <- StateChange{res.Turn, Quitting}
-		closeTickerRoutine <- true
-		close(c.events)
-		return
+		return nil
 	}
 
 	// utilise the response
-	res := new(TickerResponse)
-	err = broker.Call(ReportAliveCellsHandler, new(EmptyRequest), res)
+	res, err := engine.AliveCells()
 	if err != nil {
-		panic(err)
+		return err
 	}
+	reportLostWorkers(c, res)
 	aliveCells := res.AliveCells
 
 	// Send the filename to write the image in.
-	req2 := new(EmptyRequest)
-	res2 := new(Response)
-	err = broker.Call(CurrentWorldStateHandler, req2, res2)
+	res2, err := engine.State()
 	if err != nil {
-		return
+		return err
 	}
-	saveImage(p, c, res2.FinalBoard, fmt.Sprintf("%dx%dx%d", p.ImageWidth, p.ImageHeight, res2.Turn))
+	err = saveImage(p, c, res2.FinalBoard, s.outputs.name(p, res2.Turn))
+	if err != nil {
+		return err
+	}
+
+	// Show every turn in the SDL window before reporting the final state.
+	streamer.stopAt(response.Turn)
 
 	// Report the final state using FinalTurnCompleteEvent.
 	FinalTurnCompleteEvent := FinalTurnComplete{response.Turn, aliveCells}
 	c.events <- FinalTurnCompleteEvent
 
 	// Make sure that the Io has finished any output before exiting.
-	c.ioCommand <- ioCheckIdle
-	<-c.ioIdle
+	if err := waitForIo(c); err != nil {
+		return err
+	}
 
 	c.events <- StateChange{response.Turn, Quitting}
 
-	// Close the channel to stop the SDL goroutine gracefully. Removing may cause deadlock.
-	closeTickerRoutine <- true
-	close(c.events)
+	// The deferred close of the channel stops the SDL goroutine gracefully. Removing it may
+	// cause deadlock.
+	return nil
 }
@@ -0,0 +1,42 @@
+package gol
+
+import "testing"
+
+// TestOutputNames checks that runs going on at once are given different names for images of the
+// same size and turn in the same directory, and that a run keeps the name it was given.
+func TestOutputNames(t *testing.T) {
+	dir := t.TempDir()
+	p := Params{ImageWidth: 16, ImageHeight: 8, OutputDir: dir}
+	var first, second, third outputNames
+	tests := []struct {
+		run  *outputNames
+		p    Params
+		turn int
+		want string
+	}{
+		{&first, p, 10, "16x8x10"},
+		{&second, p, 10, "16x8x10-2"},
+		{&second, p, 10, "16x8x10-2"},
+		{&third, Params{ImageWidth: 16, ImageHeight: 8, OutputDir: dir + "/."}, 10, "16x8x10-3"},
+		{&third, Params{ImageWidth: 16, ImageHeight: 8, OutputDir: dir + "/other"}, 10, "16x8x10"},
+		{&first, p, 10, "16x8x10"},
+		{&second, p, 11, "16x8x11"},
+	}
+	for i, test := range tests {
+		if got := test.run.name(test.p, test.turn); got != test.want {
+			t.Errorf("name %v is %v, want %v", i, got, test.want)
+		}
+	}
+
+	first.release()
+	if got := third.name(p, 10); got != "16x8x10-3" {
+		t.Errorf("name after releasing is %v, want the run to keep 16x8x10-3", got)
+	}
+	var fourth outputNames
+	if got := fourth.name(p, 10); got != "16x8x10" {
+		t.Errorf("name after releasing is %v, want 16x8x10", got)
+	}
+	second.release()
+	third.release()
+	fourth.release()
+}
@@ -0,0 +1,265 @@
+package gol
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/rpc"
+	"os"
+	"sync"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// Engine runs simulations for the distributor. The broker, which shares the world between its
+// servers, is one engine; the local engine runs the simulation in this process.
+type Engine interface {
+	// Init loads a world to simulate with the given params.
+	Init(p Params, world util.BitBoard) error
+	// Evolve runs the simulation until it has completed p.Turns turns, or until it is quit,
+	// terminated or the client detaches, and returns how it ended.
+	Evolve(p Params, world util.BitBoard) (*Response, error)
+	// State returns the current world and turn, whether the simulation is paused, and its params.
+	State() (*Response, error)
+	// AliveCells returns the alive cells at the current turn, with any servers lost and any
+	// cycle found since the last call.
+	AliveCells() (*TickerResponse, error)
+	// Flips returns the cells flipped in each turn after the given one, or the current world
+	// if those turns are no longer kept.
+	Flips(turn int) (*FlipsResponse, error)
+	// Pause pauses the simulation, or resumes it if it is paused.
+	Pause() error
+	// Advance runs a paused simulation for the given number of turns, returning the turn it
+	// has paused again at, or has stopped at if it ended first.
+	Advance(turns int) (*Response, error)
+	// Quit stops the simulation.
+	Quit() error
+	// Detach leaves the simulation running without the client.
+	Detach() error
+	// Terminate stops the simulation and shuts the engine down. The broker refuses while it is
+	// running other simulations.
+	Terminate() error
+	// Close releases the engine's resources.
+	Close() error
+}
+
+// engineName is the engine to run a simulation on: p.Engine if it is set, otherwise the
+// GOL_ENGINE environment variable, otherwise the broker.
+func engineName(p Params) string {
+	if p.Engine != "" {
+		return p.Engine
+	}
+	if name := os.Getenv("GOL_ENGINE"); name != "" {
+		return name
+	}
+	return "broker"
+}
+
+// CheckEngine reports an error if the engine in p is not known.
+func CheckEngine(p Params) error {
+	switch name := engineName(p); name {
+	case "broker", "local":
+		return nil
+	default:
+		return fmt.Errorf("unknown engine %q, want broker or local", name)
+	}
+}
+
+// newEngine returns the engine to run a simulation with the given params on, giving up on
+// dialling the broker if ctx is cancelled.
+func newEngine(ctx context.Context, p Params) (Engine, error) {
+	if err := CheckEngine(p); err != nil {
+		return nil, err
+	}
+	if engineName(p) == "local" {
+		return newLocalEngine(), nil
+	}
+
+	address := brokerAddress()
+	conn, err := new(net.Dialer).DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, &BrokerUnreachableError{Address: address, Err: err}
+	}
+	engine := &brokerEngine{ctx: ctx, client: rpc.NewClient(conn), address: address, session: p.Session}
+	if p.Attach {
+		res := new(SessionResponse)
+		err = engine.call(AttachHandler, SessionRequest{Session: p.Session}, res)
+		if err != nil {
+			engine.client.Close()
+			if _, ok := err.(rpc.ServerError); ok {
+				err = fmt.Errorf("attaching: %v", err)
+			}
+			return nil, err
+		}
+		engine.session = res.Session
+	}
+	return engine, nil
+}
+
+// SessionParams returns the params of the simulation in the broker session that attaching with
+// p would join, with Session set to its ID, without joining it, so that main can open a window
+// the size of its world.
+func SessionParams(p Params) (Params, error) {
+	p.Attach = true
+	return sessionParams(context.Background(), p)
+}
+
+// sessionParams is SessionParams, giving up if ctx is cancelled. The session is left open for
+// the client attaching to it next.
+func sessionParams(ctx context.Context, p Params) (Params, error) {
+	engine, err := newEngine(ctx, p)
+	if err != nil {
+		return Params{}, err
+	}
+	b, ok := engine.(*brokerEngine)
+	if !ok {
+		return Params{}, fmt.Errorf("only simulations on the broker can be attached to")
+	}
+	defer b.client.Close()
+	res, err := b.State()
+	if err != nil {
+		return Params{}, err
+	}
+	running := res.P
+	running.Session = b.session
+	return running, nil
+}
+
+var defineBrokerFlag sync.Once
+
+// brokerAddress is the address given by the -broker flag, which is defined here if main has not
+// defined it. Runs going on at once all define it through the same sync.Once.
+func brokerAddress() string {
+	defineBrokerFlag.Do(func() {
+		if flag.Lookup("broker") == nil {
+			flag.String("broker", "localhost:8030", "IP:port string to connect to as broker")
+			flag.Parse()
+		}
+	})
+	return flag.Lookup("broker").Value.String()
+}
+
+// abandonTimeout is how long a cancelled run waits for the broker to quit its session before
+// hanging up regardless.
+const abandonTimeout = time.Second
+
+// brokerEngine runs a simulation in one of the broker's sessions over RPC.
+type brokerEngine struct {
+	ctx       context.Context // the run's context, cancelling every call in progress
+	client    *rpc.Client
+	address   string
+	session   string // empty until the broker has given a new session its ID
+	abandoned sync.Once
+}
+
+// call makes an RPC call to the broker. Errors other than those the broker returns mean the
+// connection to it has been lost. If the run's context is cancelled first, the session is
+// abandoned and ctx.Err() is returned without waiting for the reply.
+func (b *brokerEngine) call(method string, req interface{}, res interface{}) error {
+	call := b.client.Go(method, req, res, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+	case <-b.ctx.Done():
+		b.abandon()
+		return b.ctx.Err()
+	}
+	err := call.Error
+	if _, ok := err.(rpc.ServerError); err != nil && !ok {
+		return &BrokerUnreachableError{Address: b.address, Err: err}
+	}
+	return err
+}
+
+// callWithin makes an RPC call, giving up on it after the given time.
+func (b *brokerEngine) callWithin(timeout time.Duration, method string, req interface{}, res interface{}) {
+	call := b.client.Go(method, req, res, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+	case <-time.After(timeout):
+	}
+}
+
+// abandon quits the session and lets the broker forget it, waiting a short time for each, then
+// hangs up, which ends any calls still waiting for the broker.
+func (b *brokerEngine) abandon() {
+	b.abandoned.Do(func() {
+		if b.session != "" {
+			b.callWithin(abandonTimeout, QuitHandler, SessionRequest{Session: b.session}, new(EmptyResponse))
+			b.callWithin(abandonTimeout, CloseSessionHandler, SessionRequest{Session: b.session}, new(EmptyResponse))
+		}
+		b.client.Close()
+	})
+}
+
+func (b *brokerEngine) Init(p Params, world util.BitBoard) error {
+	res := new(SessionResponse)
+	err := b.call(InitialiseBoardAndTurnHandler, Request{P: p, World: world, Session: b.session}, res)
+	if err != nil {
+		return err
+	}
+	b.session = res.Session
+	return nil
+}
+
+func (b *brokerEngine) Evolve(p Params, world util.BitBoard) (*Response, error) {
+	res := new(Response)
+	err := b.call(GOLHandler, Request{P: p, World: world, Session: b.session}, res)
+	return res, err
+}
+
+func (b *brokerEngine) State() (*Response, error) {
+	res := new(Response)
+	err := b.call(CurrentWorldStateHandler, SessionRequest{Session: b.session}, res)
+	return res, err
+}
+
+func (b *brokerEngine) AliveCells() (*TickerResponse, error) {
+	res := new(TickerResponse)
+	err := b.call(ReportAliveCellsHandler, SessionRequest{Session: b.session}, res)
+	return res, err
+}
+
+func (b *brokerEngine) Flips(turn int) (*FlipsResponse, error) {
+	res := new(FlipsResponse)
+	err := b.call(FlipsHandler, FlipsRequest{Session: b.session, Turn: turn}, res)
+	return res, err
+}
+
+func (b *brokerEngine) Pause() error {
+	return b.call(PauseHandler, SessionRequest{Session: b.session}, new(EmptyResponse))
+}
+
+func (b *brokerEngine) Advance(turns int) (*Response, error) {
+	res := new(Response)
+	err := b.call(AdvanceHandler, AdvanceRequest{Session: b.session, Turns: turns}, res)
+	return res, err
+}
+
+func (b *brokerEngine) Quit() error {
+	return b.call(QuitHandler, SessionRequest{Session: b.session}, new(EmptyResponse))
+}
+
+func (b *brokerEngine) Detach() error {
+	err := b.call(DetachHandler, SessionRequest{Session: b.session}, new(EmptyResponse))
+	if err == nil {
+		fmt.Printf("Session %v is still running on the broker\n", b.session)
+	}
+	return err
+}
+
+func (b *brokerEngine) Terminate() error {
+	return b.call(TerminateBrokerHandler, SessionRequest{Session: b.session}, new(EmptyResponse))
+}
+
+// Close lets the broker forget the session, unless the client has detached from it, before
+// hanging up. A cancelled run has done so already.
+func (b *brokerEngine) Close() error {
+	var err error
+	b.abandoned.Do(func() {
+		b.callWithin(abandonTimeout, CloseSessionHandler, SessionRequest{Session: b.session}, new(EmptyResponse))
+		err = b.client.Close()
+	})
+	return err
+}
@@ -0,0 +1,76 @@
+package gol
+
+import "fmt"
+
+// RunContext returns these errors for the failures an application running simulations may want
+// to handle. Each wraps the error that caused it, so errors.As picks out the kind of failure and
+// errors.Is still sees the cause.
+
+// BrokerUnreachableError is returned when the broker cannot be dialled, or the connection to it
+// is lost part way through a simulation.
+type BrokerUnreachableError struct {
+	Address string
+	Err     error
+}
+
+func (e *BrokerUnreachableError) Error() string {
+	return fmt.Sprintf("broker %v unreachable: %v", e.Address, e.Err)
+}
+
+func (e *BrokerUnreachableError) Unwrap() error {
+	return e.Err
+}
+
+// ImageNotFoundError is returned when the image or pattern to load the world from does not exist.
+type ImageNotFoundError struct {
+	Path string
+	Err  error
+}
+
+func (e *ImageNotFoundError) Error() string {
+	return fmt.Sprintf("image %v not found: %v", e.Path, e.Err)
+}
+
+func (e *ImageNotFoundError) Unwrap() error {
+	return e.Err
+}
+
+// DimensionsError is returned when the world cannot be the size in the params: the size is not
+// positive, the image is a different size, a pattern does not fit on the board, or the
+// simulation being attached to is a different size.
+type DimensionsError struct {
+	Width, Height int // the size in the params
+	Err           error
+}
+
+func (e *DimensionsError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DimensionsError) Unwrap() error {
+	return e.Err
+}
+
+// ParamsError is returned when the params ask for a simulation that cannot be run, with an
+// unknown rule, topology, engine or output format for example. A bad size is a DimensionsError.
+type ParamsError struct {
+	Err error
+}
+
+func (e *ParamsError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ParamsError) Unwrap() error {
+	return e.Err
+}
+
+// WorkerLostError is returned when the broker lost every server it was running the simulation
+// on and none registered to take over in time. The world was saved as it was at Turn.
+type WorkerLostError struct {
+	Turn int
+}
+
+func (e *WorkerLostError) Error() string {
+	return fmt.Sprintf("every server was lost, and none registered to carry on from turn %v", e.Turn)
+}
@@ -66,6 +66,7 @@ type CellsFlipped struct { // implements Event
 // `TurnComplete` is an Event notifying the GUI about turn completion.
 // SDL will render a frame when this event is sent.
 // All `CellFlipped` or `CellsFlipped` events must be sent *before* `TurnComplete`.
+// It is only sent when `Params.Stream` is set, including for turns run by advancing a paused simulation.
 type TurnComplete struct { // implements Event
 	CompletedTurns int
 }
@@ -78,6 +79,20 @@ type FinalTurnComplete struct {
 	Alive          []util.Cell
 }
 
+// `WorkerLost` is an Event notifying the user that a server stopped responding.
+// Its rows have been reassigned to the remaining servers and the simulation carries on.
+type WorkerLost struct {
+	CompletedTurns int
+	Address        string
+}
+
+// `CycleDetected` is an Event notifying the user that the world has started repeating itself.
+// The world at CompletedTurns is the same as it was Period turns before.
+type CycleDetected struct {
+	CompletedTurns int
+	Period         int
+}
+
 // String methods allow the different types of Events and States to be printed.
 
 func (state State) String() string {
@@ -141,6 +156,25 @@ func (event TurnComplete) GetCompletedTurns() int {
 	return event.CompletedTurns
 }
 
+func (event WorkerLost) String() string {
+	return fmt.Sprintf("Server %v lost, work reassigned", event.Address)
+}
+
+func (event WorkerLost) GetCompletedTurns() int {
+	return event.CompletedTurns
+}
+
+func (event CycleDetected) String() string {
+	if event.Period == 1 {
+		return "World is still"
+	}
+	return fmt.Sprintf("World repeats every %v turns", event.Period)
+}
+
+func (event CycleDetected) GetCompletedTurns() int {
+	return event.CompletedTurns
+}
+
 func (event FinalTurnComplete) String() string {
 	return "Final Turn Complete"
 }
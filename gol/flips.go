@@ -0,0 +1,139 @@
+package gol
+
+import (
+	"sync"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// flipStreamer forwards the cells flipped by the engine to the SDL window as CellsFlipped and
+// TurnComplete events, keeping its own copy of the board so it can catch up after falling behind.
+// A nil streamer, for a run with no window to show the board in, shows nothing.
+type flipStreamer struct {
+	engine Engine
+	c      distributorChannels
+	world  util.BitBoard
+	mutex  sync.Mutex // held while turn is changed, and when it is read by other goroutines
+	turn   int
+	finish chan int // the turn to stop at, or -1 to stop straight away
+	done   chan struct{}
+}
+
+// startFlipStreamer shows the initial board and starts streaming the turns after it.
+func startFlipStreamer(engine Engine, c distributorChannels, world util.BitBoard, turn int) *flipStreamer {
+	s := &flipStreamer{
+		engine: engine,
+		c:      c,
+		world:  copyBoard(world),
+		turn:   turn,
+		finish: make(chan int, 1),
+		done:   make(chan struct{}),
+	}
+	c.events <- CellsFlipped{turn, world.AliveCells()}
+	go s.run()
+	return s
+}
+
+// stopAt waits until every turn up to the given one has been shown. A negative turn stops
+// the streamer without waiting. It may be called again after the streamer has stopped.
+func (s *flipStreamer) stopAt(turn int) {
+	if s == nil {
+		return
+	}
+	select {
+	case s.finish <- turn:
+	case <-s.done:
+	}
+	<-s.done
+}
+
+// waitUntil waits until every turn up to the given one has been shown, or the streamer has
+// stopped.
+func (s *flipStreamer) waitUntil(turn int) {
+	if s == nil {
+		return
+	}
+	for {
+		s.mutex.Lock()
+		shown := s.turn
+		s.mutex.Unlock()
+		if shown >= turn {
+			return
+		}
+		select {
+		case <-s.done:
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// setTurn records the last turn shown.
+func (s *flipStreamer) setTurn(turn int) {
+	s.mutex.Lock()
+	s.turn = turn
+	s.mutex.Unlock()
+}
+
+func (s *flipStreamer) run() {
+	defer close(s.done)
+	target := -1
+	for {
+		select {
+		case t := <-s.finish:
+			if t < 0 {
+				return
+			}
+			target = t
+		default:
+		}
+		if target >= 0 && s.turn >= target {
+			return
+		}
+
+		res, err := s.engine.Flips(s.turn)
+		if err != nil {
+			return
+		}
+		if res.Resync {
+			// Too far behind for the engine's log, so skip straight to its current world.
+			s.c.events <- CellsFlipped{res.Turn, boardDifference(s.world, res.World)}
+			s.c.events <- TurnComplete{res.Turn}
+			s.world = res.World
+			s.setTurn(res.Turn)
+			continue
+		}
+		for _, turn := range res.Turns {
+			for _, cell := range turn.Cells {
+				s.world.Set(cell.X, cell.Y, !s.world.Alive(cell.X, cell.Y))
+			}
+			s.c.events <- CellsFlipped{turn.Turn, turn.Cells}
+			s.c.events <- TurnComplete{turn.Turn}
+			s.setTurn(turn.Turn)
+		}
+		if len(res.Turns) == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+// boardDifference lists the cells that differ between two boards of the same size.
+func boardDifference(a, b util.BitBoard) []util.Cell {
+	diff := util.NewBitBoard(a.Width, a.Height)
+	for y := range diff.Rows {
+		for w := range diff.Rows[y] {
+			diff.Rows[y][w] = a.Rows[y][w] ^ b.Rows[y][w]
+		}
+	}
+	return diff.AliveCells()
+}
+
+// copyBoard returns a board that can be changed without affecting the original.
+func copyBoard(board util.BitBoard) util.BitBoard {
+	c := util.NewBitBoard(board.Width, board.Height)
+	for y := range c.Rows {
+		copy(c.Rows[y], board.Rows[y])
+	}
+	return c
+}
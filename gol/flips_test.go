@@ -0,0 +1,89 @@
+package gol
+
+import (
+	"math/rand"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// scriptedFlips is an engine that streams the flips between a list of boards, a few turns at a
+// time, except that it no longer has those of turns from lostFrom up to resyncTo and sends the
+// world at resyncTo instead. Nothing else about it is used.
+type scriptedFlips struct {
+	Engine
+	boards             []util.BitBoard
+	lostFrom, resyncTo int
+}
+
+func (e *scriptedFlips) Flips(turn int) (*FlipsResponse, error) {
+	if turn >= e.lostFrom && turn < e.resyncTo {
+		return &FlipsResponse{Turn: e.resyncTo, World: copyBoard(e.boards[e.resyncTo]), Resync: true}, nil
+	}
+	res := &FlipsResponse{Turn: turn}
+	for t := turn + 1; t <= turn+3 && t < len(e.boards); t++ {
+		res.Turns = append(res.Turns, TurnFlips{Turn: t, Cells: boardDifference(e.boards[t-1], e.boards[t])})
+		res.Turn = t
+	}
+	return res, nil
+}
+
+// TestFlipStreamer checks that applying the CellsFlipped events the streamer sends to a blank
+// board gives the world at every TurnComplete, across a resync as well as turn by turn.
+func TestFlipStreamer(t *testing.T) {
+	size, turns := 32, 30
+	rule, _ := util.ParseRule("B3/S23")
+	rng := rand.New(rand.NewSource(1))
+	boards := []util.BitBoard{util.NewBitBoard(size, size)}
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			boards[0].Set(x, y, rng.Intn(3) == 0)
+		}
+	}
+	for turn := 1; turn <= turns; turn++ {
+		last := boards[turn-1]
+		rows := append(append([][]uint64{last.Rows[size-1]}, last.Rows...), last.Rows[0])
+		next := util.NextRows(size, rule, util.Torus, rows, util.AllWords(size, util.WordsPerRow(size)), 1)
+		boards = append(boards, util.BitBoard{Width: size, Height: size, AgePlanes: last.AgePlanes, Rows: next})
+	}
+
+	events := make(chan Event, 1000)
+	engine := &scriptedFlips{boards: boards, lostFrom: 7, resyncTo: 16}
+	streamer := startFlipStreamer(engine, distributorChannels{events: events}, boards[0], 0)
+	stopped := make(chan struct{})
+	go func() {
+		streamer.stopAt(turns)
+		close(events)
+		close(stopped)
+	}()
+
+	view := util.NewBitBoard(size, size)
+	shown := 0
+	for event := range events {
+		switch e := event.(type) {
+		case CellsFlipped:
+			for _, cell := range e.Cells {
+				view.Set(cell.X, cell.Y, !view.Alive(cell.X, cell.Y))
+			}
+		case TurnComplete:
+			if e.CompletedTurns <= shown {
+				t.Fatalf("turn %v completed after turn %v", e.CompletedTurns, shown)
+			}
+			if e.CompletedTurns > shown+1 && e.CompletedTurns != engine.resyncTo {
+				t.Fatalf("skipped from turn %v to %v without a resync", shown, e.CompletedTurns)
+			}
+			shown = e.CompletedTurns
+			for y := 0; y < size; y++ {
+				for x := 0; x < size; x++ {
+					if view.Alive(x, y) != boards[shown].Alive(x, y) {
+						t.Fatalf("turn %v: cell (%v, %v) is alive %v, want %v", shown, x, y, view.Alive(x, y), boards[shown].Alive(x, y))
+					}
+				}
+			}
+		}
+	}
+	<-stopped
+	if shown != turns {
+		t.Errorf("shown up to turn %v, want %v", shown, turns)
+	}
+}
@@ -1,21 +1,105 @@
 package gol
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
 // Params provides the details of how to run the Game of Life and which image to load.
 type Params struct {
 	Turns       int
 	Threads     int
-	ImageWidth  int
-	ImageHeight int
+	ImageWidth  int        // width of the world, 0 along with ImageHeight to take the size from the Input image
+	ImageHeight int        // height of the world, 0 along with ImageWidth to take the size from the Input image
+	Rule        string     // rule in B/S or B/S/C notation, empty for Conway's B3/S23
+	Topology    string     // how the edges are joined: torus, plane, cylinder or klein, empty for torus
+	Algorithm   string     // strips to share the world between the servers, or hashlife to run it on the broker
+	MaxPeriod   int        // longest cycle to look for, 0 to not look for cycles
+	StopOnCycle bool       // skip ahead to the last turn once a cycle is found
+	Stream      bool       // send CellsFlipped and TurnComplete events each turn for a window; TurnComplete is only sent with it
+	Attach      bool       // connect to a simulation already running on the broker instead of loading an image
+	Session     string     // the broker session to run in or attach to, empty for a new one or the only one running
+	Input       string     // image to load the world from, empty for images/WxH.pgm
+	OutputDir   string     // directory images are written to, empty for out
+	Format      string     // format images are written in: pgm, png, rle, cells or lif, empty for pgm
+	Offset      *util.Cell // where the top left of a pattern goes, or its origin for Life 1.06, nil to centre it
+	AliveColour string     // colour of alive cells in a png, as #rrggbb, empty for white
+	DeadColour  string     // colour of dead cells in a png, as #rrggbb, empty for black
+	Scale       int        // pixels along each side of a cell in a png, 0 for 1
+	Engine      string     // broker, or local to run in this process, empty for $GOL_ENGINE or else broker
 }
 
 // Run starts the processing of Game of Life. It should initialise channels and goroutines.
+// If the simulation cannot be run, the error is logged and the program exits.
 func Run(p Params, events chan<- Event, keyPresses <-chan rune) {
+	err := RunContext(context.Background(), p, events, keyPresses)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// RunContext runs the Game of Life like Run, returning once the simulation is over and events
+// has been closed. If the simulation cannot be run, the error is returned instead of exiting;
+// errors.go lists the kinds worth telling apart. If ctx is cancelled, the simulation is quit
+// without saving an image, every goroutine started for it is stopped and ctx.Err() is returned.
+// A client attaching takes the size, turns and rule of the simulation it attaches to; a size
+// given that does not match the running world is a DimensionsError. Images are named WxHxT,
+// or WxHxT-2 and so on if another run going on in this process has written one of that name to
+// the same directory.
+func RunContext(ctx context.Context, p Params, events chan<- Event, keyPresses <-chan rune) error {
+	if p.Rule == "" && !p.Attach {
+		// A pattern file may say which rule it is for.
+		rule, err := PatternRule(inputPath(p))
+		if err == nil {
+			p.Rule = rule
+		}
+	}
+	if p.ImageWidth == 0 && p.ImageHeight == 0 && p.Input != "" && !p.Attach && !IsPattern(p.Input) {
+		width, height, err := ReadImageSize(p.Input)
+		if errors.Is(err, os.ErrNotExist) {
+			err = &ImageNotFoundError{Path: p.Input, Err: err}
+		}
+		if err != nil {
+			close(events)
+			return err
+		}
+		p.ImageWidth, p.ImageHeight = width, height
+	}
+	if err := checkParams(p); err != nil {
+		close(events)
+		return err
+	}
+	if p.Attach {
+		running, err := sessionParams(ctx, p)
+		if err == nil && (p.ImageWidth != 0 || p.ImageHeight != 0) &&
+			(running.ImageWidth != p.ImageWidth || running.ImageHeight != p.ImageHeight) {
+			err = &DimensionsError{p.ImageWidth, p.ImageHeight, fmt.Errorf("attaching: the broker is running a %vx%v world, not %vx%v",
+				running.ImageWidth, running.ImageHeight, p.ImageWidth, p.ImageHeight)}
+		}
+		if err != nil {
+			close(events)
+			return err
+		}
+		// Carry on with the simulation the broker is already running.
+		p.ImageWidth, p.ImageHeight = running.ImageWidth, running.ImageHeight
+		p.Session = running.Session
+		p.Turns = running.Turns
+		p.Rule = running.Rule
+		p.Topology = running.Topology
+		p.Algorithm = running.Algorithm
+	}
 
 	ioCommand := make(chan ioCommand)
-	ioIdle := make(chan bool)
+	ioIdle := make(chan error)
 	ioFilename := make(chan string)
 	ioOutput := make(chan uint8)
 	ioInput := make(chan uint8)
+	ioInputErr := make(chan error)
 
 	ioChannels := ioChannels{
 		command:  ioCommand,
@@ -23,6 +107,7 @@ func Run(p Params, events chan<- Event, keyPresses <-chan rune) {
 		filename: ioFilename,
 		output:   ioOutput,
 		input:    ioInput,
+		inputErr: ioInputErr,
 	}
 	go startIo(p, ioChannels)
 
@@ -33,6 +118,30 @@ func Run(p Params, events chan<- Event, keyPresses <-chan rune) {
 		ioFilename: ioFilename,
 		ioOutput:   ioOutput,
 		ioInput:    ioInput,
+		ioInputErr: ioInputErr,
+	}
+	return distributor(ctx, p, keyPresses, distributorChannels)
+}
+
+// checkParams reports an error if a simulation cannot be run with p.
+func checkParams(p Params) error {
+	if !p.Attach && (p.ImageWidth < 1 || p.ImageHeight < 1) {
+		return &DimensionsError{p.ImageWidth, p.ImageHeight, fmt.Errorf("a %vx%v world has no cells", p.ImageWidth, p.ImageHeight)}
+	}
+	if _, err := util.ParseRule(p.Rule); err != nil {
+		return &ParamsError{err}
+	}
+	if _, err := util.ParseTopology(p.Topology); err != nil {
+		return &ParamsError{err}
+	}
+	if err := CheckEngine(p); err != nil {
+		return &ParamsError{err}
+	}
+	if p.Attach && engineName(p) != "broker" {
+		return &ParamsError{errors.New("attaching: only the broker keeps simulations running without a client")}
+	}
+	if err := CheckOutput(p); err != nil {
+		return &ParamsError{err}
 	}
-	distributor(p, keyPresses, distributorChannels)
+	return nil
 }
@@ -0,0 +1,106 @@
+package gol
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestRunContextImageSize checks that the size of the world is read from the input image when
+// the params leave it out, and that a missing image is an ImageNotFoundError.
+func TestRunContextImageSize(t *testing.T) {
+	// A blinker in the middle of a 5x3 world, which is vertical after one turn.
+	path := writeTemp(t, "blinker.pgm", []byte("P2 5 3 255\n0 0 0 0 0\n0 255 255 255 0\n0 0 0 0 0\n"))
+	p := Params{Turns: 1, Threads: 1, Input: path, OutputDir: t.TempDir(), Engine: "local"}
+	events := make(chan Event, 1000)
+	err := RunContext(context.Background(), p, events, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var final *FinalTurnComplete
+	for event := range events {
+		if e, ok := event.(FinalTurnComplete); ok {
+			final = &e
+		}
+	}
+	if final == nil {
+		t.Fatal("no FinalTurnComplete event")
+	}
+	if len(final.Alive) != 3 {
+		t.Fatalf("alive cells %v, want 3", final.Alive)
+	}
+	for _, cell := range final.Alive {
+		if cell.X != 2 {
+			t.Errorf("alive cells %v, want the column x = 2", final.Alive)
+		}
+	}
+
+	p.Input = path + ".missing"
+	err = RunContext(context.Background(), p, make(chan Event, 1000), nil)
+	var notFound *ImageNotFoundError
+	if !errors.As(err, &notFound) {
+		t.Errorf("error %v, want an ImageNotFoundError", err)
+	}
+}
+
+// TestRunContextCancel checks that cancelling a run stops it with context.Canceled, and that
+// events is closed.
+func TestRunContextCancel(t *testing.T) {
+	path := writeTemp(t, "blinker.pgm", []byte("P2 5 3 255\n0 0 0 0 0\n0 255 255 255 0\n0 0 0 0 0\n"))
+	p := Params{Turns: 1000000000, Threads: 1, Input: path, OutputDir: t.TempDir(), Engine: "local"}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	events := make(chan Event)
+	drained := make(chan struct{})
+	go func() {
+		for range events {
+		}
+		close(drained)
+	}()
+	err := RunContext(ctx, p, events, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("error %v, want context.Canceled", err)
+	}
+	<-drained
+}
+
+// TestRunContextErrors checks that params a simulation cannot be run with, and worlds that do not
+// fit, come back as errors of the kinds in errors.go rather than stopping the program.
+func TestRunContextErrors(t *testing.T) {
+	pgm := writeTemp(t, "blinker.pgm", []byte("P2 5 3 255\n0 0 0 0 0\n0 255 255 255 0\n0 0 0 0 0\n"))
+	rle := writeTemp(t, "large.rle", []byte("x = 100, y = 100, rule = B3/S23\nbo$2bo$3o!\n"))
+	base := Params{ImageWidth: 16, ImageHeight: 16, Turns: 1, Threads: 1, Input: pgm, OutputDir: t.TempDir(), Engine: "local"}
+	tests := []struct {
+		name       string
+		change     func(p *Params)
+		dimensions bool // whether a DimensionsError is wanted rather than a ParamsError
+	}{
+		{"no cells", func(p *Params) { p.ImageWidth = 0 }, true},
+		{"image size", func(p *Params) {}, true},
+		{"pattern too large", func(p *Params) { p.Input = rle }, true},
+		{"rule", func(p *Params) { p.Rule = "B9/S" }, false},
+		{"topology", func(p *Params) { p.Topology = "sphere" }, false},
+		{"engine", func(p *Params) { p.Engine = "gpu" }, false},
+		{"format", func(p *Params) { p.Format = "jpeg" }, false},
+		{"attach locally", func(p *Params) { p.Attach = true }, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := base
+			test.change(&p)
+			events := make(chan Event, 1000)
+			err := RunContext(context.Background(), p, events, nil)
+			for range events {
+			}
+			var dimensions *DimensionsError
+			var params *ParamsError
+			if test.dimensions && !errors.As(err, &dimensions) {
+				t.Errorf("error %v, want a DimensionsError", err)
+			}
+			if !test.dimensions && !errors.As(err, &params) {
+				t.Errorf("error %v, want a ParamsError", err)
+			}
+		})
+	}
+}
@@ -1,26 +1,30 @@
 package gol
 
 import (
+	"errors"
 	"fmt"
+	goio "io"
 	"os"
+	"path/filepath"
 	"strconv"
-	"strings"
 	"uk.ac.bris.cs/gameoflife/util"
 )
 
 type ioChannels struct {
 	command <-chan ioCommand
-	idle    chan<- bool
+	idle    chan<- error // the error any output since the last check failed with, or nil
 
 	filename <-chan string
 	output   <-chan uint8
 	input    chan<- uint8
+	inputErr chan<- error // why the image could not be read, or nil before its pixels are sent
 }
 
 // ioState is the internal ioState of the io goroutine.
 type ioState struct {
 	params   Params
 	channels ioChannels
+	err      error // the first output to fail since the last check
 }
 
 // ioCommand allows requesting behaviour from the io (pgm) goroutine.
@@ -35,27 +39,152 @@ const (
 	ioOutput ioCommand = iota
 	ioInput
 	ioCheckIdle
+	ioOutputPng // ioOutput as a png whatever the output format
 )
 
-// writePgmImage receives an array of bytes and writes it to a pgm file.
-func (io *ioState) writePgmImage() {
-	_ = os.Mkdir("out", os.ModePerm)
+// outputDir is the directory images are written to.
+func outputDir(p Params) string {
+	if p.OutputDir == "" {
+		return "out"
+	}
+	return p.OutputDir
+}
+
+// inputPath is the image the initial world is read from.
+func inputPath(p Params) string {
+	if p.Input == "" {
+		return filepath.Join("images", fmt.Sprintf("%vx%v.pgm", p.ImageWidth, p.ImageHeight))
+	}
+	return p.Input
+}
+
+// outputFormat is the format images are written in.
+func outputFormat(p Params) string {
+	if p.Format == "" {
+		return "pgm"
+	}
+	return p.Format
+}
+
+// patternWriter writes a board of cell states to a pattern file.
+type patternWriter func(w goio.Writer, width, height int, rule util.Rule, state func(x, y int) int) error
+
+// patternWriters write each pattern format, by the name of the format, which is its extension.
+var patternWriters = map[string]patternWriter{
+	"rle":   writeRLE,
+	"cells": writePlaintext,
+	"lif":   writeLife106,
+}
+
+// CheckOutput reports an error if images cannot be written with the format, colours and scale in p.
+func CheckOutput(p Params) error {
+	if _, ok := patternWriters[p.Format]; !ok && p.Format != "" && p.Format != "pgm" && p.Format != "png" {
+		return fmt.Errorf("unknown format %q, want pgm, png, rle, cells or lif", p.Format)
+	}
+	if p.Scale < 0 {
+		return fmt.Errorf("scale %v is negative", p.Scale)
+	}
+	_, err := pngPalette(p)
+	return err
+}
+
+// writeImage receives an array of bytes and writes it to a file in the given format. If it
+// fails, the error is kept for the next check.
+func (io *ioState) writeImage(format string) {
+	var err error
+	if write, ok := patternWriters[format]; ok {
+		err = io.writePatternImage(format, write)
+	} else if format == "png" {
+		err = io.writePngImage()
+	} else {
+		err = io.writePgmImage()
+	}
+	if err != nil && io.err == nil {
+		io.err = err
+	}
+}
+
+// writePngImage receives an array of bytes and writes it to a png file in the colours and at
+// the scale in the params.
+func (io *ioState) writePngImage() error {
+	dir := outputDir(io.params)
+	_ = os.MkdirAll(dir, os.ModePerm)
 
 	// Request a filename from the distributor.
 	filename := <-io.channels.filename
 
-	file, ioError := os.Create("out/" + filename + ".pgm")
-	util.Check(ioError)
+	width, height := io.params.ImageWidth, io.params.ImageHeight
+	greys := make([]uint8, width*height)
+	for i := range greys {
+		greys[i] = <-io.channels.output
+	}
+
+	palette, ioError := pngPalette(io.params)
+	if ioError != nil {
+		return ioError
+	}
+	file, ioError := os.Create(filepath.Join(dir, filename+".png"))
+	if ioError != nil {
+		return ioError
+	}
 	defer file.Close()
+	ioError = writePng(file, width, height, pngScale(io.params), palette, func(x, y int) uint8 {
+		return greys[y*width+x]
+	})
+	if ioError != nil {
+		return ioError
+	}
 
-	_, _ = file.WriteString("P5\n")
-	//_, _ = file.WriteString("# PGM file writer by pnmmodules (https://github.com/owainkenwayucl/pnmmodules).\n")
-	_, _ = file.WriteString(strconv.Itoa(io.params.ImageWidth))
-	_, _ = file.WriteString(" ")
-	_, _ = file.WriteString(strconv.Itoa(io.params.ImageHeight))
-	_, _ = file.WriteString("\n")
-	_, _ = file.WriteString(strconv.Itoa(255))
-	_, _ = file.WriteString("\n")
+	fmt.Println("File", filename, "output done!")
+	return nil
+}
+
+// writePatternImage receives an array of bytes and writes the states they stand for to a
+// pattern file.
+func (io *ioState) writePatternImage(format string, write patternWriter) error {
+	dir := outputDir(io.params)
+	_ = os.MkdirAll(dir, os.ModePerm)
+
+	// Request a filename from the distributor.
+	filename := <-io.channels.filename
+
+	width, height := io.params.ImageWidth, io.params.ImageHeight
+	greys := make([]uint8, width*height)
+	for i := range greys {
+		greys[i] = <-io.channels.output
+	}
+	rule, err := paramsRule(io.params)
+	if err != nil {
+		return err
+	}
+	states := make([]int, width*height)
+	for i, grey := range greys {
+		states[i] = rule.StateOfGrey(grey)
+	}
+
+	file, ioError := os.Create(filepath.Join(dir, filename+"."+format))
+	if ioError != nil {
+		return ioError
+	}
+	defer file.Close()
+	ioError = write(file, width, height, rule, func(x, y int) int {
+		return states[y*width+x]
+	})
+	if ioError != nil {
+		return ioError
+	}
+
+	fmt.Println("File", filename, "output done!")
+	return nil
+}
+
+// writePgmImage receives an array of bytes and writes it to a pgm file.
+func (io *ioState) writePgmImage() error {
+	dir := outputDir(io.params)
+	_ = os.MkdirAll(dir, os.ModePerm)
+
+	// Request a filename from the distributor.
+	filename := <-io.channels.filename
 
 	world := make([][]byte, io.params.ImageHeight)
 	for i := range world {
@@ -72,53 +201,66 @@ func (io *ioState) writePgmImage() {
 		}
 	}
 
+	file, ioError := os.Create(filepath.Join(dir, filename+".pgm"))
+	if ioError != nil {
+		return ioError
+	}
+	defer file.Close()
+
+	_, _ = file.WriteString("P5\n")
+	//_, _ = file.WriteString("# PGM file writer by pnmmodules (https://github.com/owainkenwayucl/pnmmodules).\n")
+	_, _ = file.WriteString(strconv.Itoa(io.params.ImageWidth))
+	_, _ = file.WriteString(" ")
+	_, _ = file.WriteString(strconv.Itoa(io.params.ImageHeight))
+	_, _ = file.WriteString("\n")
+	_, _ = file.WriteString(strconv.Itoa(255))
+	_, _ = file.WriteString("\n")
+
 	for y := 0; y < io.params.ImageHeight; y++ {
 		for x := 0; x < io.params.ImageWidth; x++ {
 			_, ioError = file.Write([]byte{world[y][x]})
-			util.Check(ioError)
+			if ioError != nil {
+				return ioError
+			}
 		}
 	}
 
 	ioError = file.Sync()
-	util.Check(ioError)
+	if ioError != nil {
+		return ioError
+	}
 
 	fmt.Println("File", filename, "output done!")
+	return nil
 }
 
-// readPgmImage opens a pgm file and sends its data as an array of bytes.
-func (io *ioState) readPgmImage() {
+// readImage opens a pbm or pgm image, or a pattern, and sends the grey level of each pixel in
+// turn. The whole image is read before any are sent, so that if it cannot be, only the error
+// is sent instead.
+func (io *ioState) readImage() {
 
 	// Request a filename from the distributor.
 	filename := <-io.channels.filename
 
-	data, ioError := os.ReadFile("images/" + filename + ".pgm")
-	util.Check(ioError)
-
-	fields := strings.Fields(string(data))
-
-	if fields[0] != "P5" {
-		panic("Not a pgm file")
+	greys := make([]uint8, 0, io.params.ImageWidth*io.params.ImageHeight)
+	add := func(grey uint8) {
+		greys = append(greys, grey)
 	}
-
-	width, _ := strconv.Atoi(fields[1])
-	if width != io.params.ImageWidth {
-		panic("Incorrect width")
+	var err error
+	if IsPattern(filename) {
+		err = readPattern(filename, io.params, add)
+	} else {
+		err = readPnm(filename, io.params.ImageWidth, io.params.ImageHeight, add)
 	}
-
-	height, _ := strconv.Atoi(fields[2])
-	if height != io.params.ImageHeight {
-		panic("Incorrect height")
+	if errors.Is(err, os.ErrNotExist) {
+		err = &ImageNotFoundError{Path: filename, Err: err}
 	}
-
-	maxval, _ := strconv.Atoi(fields[3])
-	if maxval != 255 {
-		panic("Incorrect maxval/bit depth")
+	io.channels.inputErr <- err
+	if err != nil {
+		return
 	}
-
-	image := []byte(fields[4])
-
-	for _, b := range image {
-		io.channels.input <- b
+	for _, grey := range greys {
+		io.channels.input <- grey
 	}
 
 	fmt.Println("File", filename, "input done!")
@@ -135,11 +277,61 @@ func startIo(p Params, c ioChannels) {
 		// Block and wait for requests from the distributor
 		switch command {
 		case ioInput:
-			io.readPgmImage()
+			io.readImage()
 		case ioOutput:
-			io.writePgmImage()
+			io.writeImage(outputFormat(io.params))
+		case ioOutputPng:
+			io.writeImage("png")
 		case ioCheckIdle:
-			io.channels.idle <- true
+			io.channels.idle <- io.err
+			io.err = nil
+		}
+	}
+}
+
+// createInitialBoard reads the input image through the io goroutine and packs it into a BitBoard.
+// Under a Generations rule, grey pixels are read as dying cells.
+func createInitialBoard(p Params, c distributorChannels) (util.BitBoard, error) {
+	rule, err := paramsRule(p)
+	if err != nil {
+		return util.BitBoard{}, err
+	}
+	world := util.NewStateBoard(p.ImageWidth, p.ImageHeight, rule.States)
+
+	// Request the image and read it.
+	c.ioCommand <- ioInput
+	c.ioFilename <- inputPath(p)
+	if err := <-c.ioInputErr; err != nil {
+		return world, err
+	}
+
+	// Populate the world array from the input.
+	for y := 0; y < p.ImageHeight; y++ {
+		for x := 0; x < p.ImageWidth; x++ {
+			world.SetState(x, y, rule.StateOfGrey(<-c.ioInput))
+		}
+	}
+	return world, nil
+}
+
+// saveImage unpacks a BitBoard into grey levels for the io goroutine to write out: 255 for
+// alive cells, 0 for dead ones and greys in between for dying ones.
+func saveImage(p Params, c distributorChannels, world util.BitBoard, filename string) error {
+	return saveImageWith(p, c, world, filename, ioOutput)
+}
+
+// saveImageWith is saveImage with the io command to write the image with.
+func saveImageWith(p Params, c distributorChannels, world util.BitBoard, filename string, command ioCommand) error {
+	rule, err := paramsRule(p)
+	if err != nil {
+		return err
+	}
+	c.ioCommand <- command
+	c.ioFilename <- filename
+	for y := 0; y < p.ImageHeight; y++ {
+		for x := 0; x < p.ImageWidth; x++ {
+			c.ioOutput <- rule.Grey(world.State(x, y))
 		}
 	}
+	return nil
 }
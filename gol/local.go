@@ -0,0 +1,310 @@
+package gol
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// localFlipsBuffer is the number of turns of flipped cells the local engine keeps for a
+// streamer that falls behind.
+const localFlipsBuffer = 256
+
+// localEngine runs a simulation in this process, splitting each turn between Params.Threads
+// goroutines. It needs no broker or servers, so it suits tests and quick experiments.
+type localEngine struct {
+	mutex         sync.Mutex
+	p             Params
+	rule          util.Rule
+	topology      util.Topology
+	world         util.BitBoard
+	changed       [][]uint64 // the words of each row that changed in the last turn
+	turn          int
+	paused        bool
+	stepBudget    int           // turns left to run while paused
+	stepDone      chan struct{} // closed once the turns Advance asked for have been run
+	stopped       *Response     // how the simulation was stopped, if it was
+	wake          chan struct{} // signalled when the simulation is resumed or stopped
+	flips         []TurnFlips   // the cells flipped in turns flipsFrom+1 onwards
+	flipsFrom     int
+	hashes        []uint64 // the hashes of the worlds of the last p.MaxPeriod turns, oldest first
+	cycleTurn     int
+	cyclePeriod   int
+	cycleReported bool
+	cycleEndTurn  int
+}
+
+func newLocalEngine() *localEngine {
+	return &localEngine{wake: make(chan struct{}, 1)}
+}
+
+func (e *localEngine) Init(p Params, world util.BitBoard) error {
+	rule, err := util.ParseRule(p.Rule)
+	if err != nil {
+		return err
+	}
+	if world.AgePlanes != util.AgePlanes(rule.States) {
+		return fmt.Errorf("a world for rule %v needs %v age planes, not %v", rule, util.AgePlanes(rule.States), world.AgePlanes)
+	}
+	topology, err := util.ParseTopology(p.Topology)
+	if err != nil {
+		return err
+	}
+	if p.Algorithm != "" && p.Algorithm != "strips" {
+		return fmt.Errorf("the local engine does not support the %v algorithm", p.Algorithm)
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.p = p
+	e.rule = rule
+	e.topology = topology
+	e.world = world
+	e.changed = util.AllWords(world.Height, util.WordsPerRow(world.Width))
+	e.turn = 0
+	e.paused = false
+	e.finishSteps()
+	e.stopped = nil
+	e.flips = nil
+	e.flipsFrom = 0
+	e.hashes = nil
+	e.cyclePeriod = 0
+	e.cycleReported = false
+	e.cycleEndTurn = -1
+	if p.MaxPeriod > 0 {
+		e.hashes = []uint64{world.Hash()}
+	}
+	return nil
+}
+
+func (e *localEngine) Evolve(p Params, world util.BitBoard) (*Response, error) {
+	for {
+		e.mutex.Lock()
+		if e.stopped != nil {
+			res := *e.stopped
+			res.Turn = e.turn
+			e.finishSteps()
+			e.mutex.Unlock()
+			return &res, nil
+		}
+		if e.paused && e.stepBudget == 0 {
+			e.mutex.Unlock()
+			<-e.wake
+			continue
+		}
+		if e.turn >= p.Turns {
+			res := &Response{FinalBoard: e.world, Turn: e.turn}
+			e.finishSteps()
+			e.mutex.Unlock()
+			return res, nil
+		}
+		if p.StopOnCycle && e.cyclePeriod != 0 {
+			if e.cycleEndTurn < 0 {
+				// Only run on to the same point in the cycle as the last turn.
+				e.cycleEndTurn = e.turn + (p.Turns-e.turn)%e.cyclePeriod
+			}
+			if e.turn == e.cycleEndTurn {
+				e.skipTo(p.Turns)
+				e.mutex.Unlock()
+				continue
+			}
+		}
+		e.step()
+		if e.paused {
+			e.stepBudget--
+			if e.stepBudget == 0 {
+				e.finishSteps()
+			}
+		}
+		e.mutex.Unlock()
+	}
+}
+
+// step advances the world by one turn.
+func (e *localEngine) step() {
+	width, height := e.world.Width, e.world.Height
+	words := util.WordsPerRow(width)
+	rows := make([][]uint64, 0, height+2)
+	rows = append(rows, e.topology.EdgeRows(width, e.world.Rows[height-1:])...)
+	rows = append(rows, e.world.Rows...)
+	rows = append(rows, e.topology.EdgeRows(width, e.world.Rows[:1])...)
+	// The rows across the top and bottom edges are treated as changed, as they may be mirrored.
+	changed := make([][]uint64, 0, height+2)
+	changed = append(changed, util.AllWords(1, words)...)
+	changed = append(changed, e.changed...)
+	changed = append(changed, util.AllWords(1, words)...)
+
+	active := util.ActiveWords(changed, words, e.topology.WrapsX())
+	next := util.NextRows(width, e.rule, e.topology, rows, active, e.p.Threads)
+	e.changed = util.ChangedWords(rows, next, words)
+	if e.p.Stream {
+		e.logFlips(util.FlippedCells(width, 0, e.world.Rows, next))
+	}
+	e.world = util.BitBoard{Width: width, Height: height, AgePlanes: e.world.AgePlanes, Rows: next}
+	e.turn++
+	if e.p.MaxPeriod > 0 && e.cyclePeriod == 0 {
+		e.recordHash(e.world.Hash())
+	}
+}
+
+// logFlips adds the cells flipped in the turn being completed, keeping only the last
+// localFlipsBuffer turns.
+func (e *localEngine) logFlips(cells []util.Cell) {
+	e.flips = append(e.flips, TurnFlips{Turn: e.turn + 1, Cells: cells})
+	if len(e.flips) > localFlipsBuffer {
+		drop := len(e.flips) - localFlipsBuffer
+		e.flips = append([]TurnFlips(nil), e.flips[drop:]...)
+		e.flipsFrom += drop
+	}
+}
+
+// recordHash checks whether the world after the current turn was seen in any recent turn.
+func (e *localEngine) recordHash(hash uint64) {
+	for i := len(e.hashes) - 1; i >= 0; i-- {
+		if e.hashes[i] == hash {
+			e.cyclePeriod = len(e.hashes) - i
+			e.cycleTurn = e.turn
+			e.hashes = nil
+			fmt.Printf("Cycle of period %v found at turn %v\n", e.cyclePeriod, e.cycleTurn)
+			return
+		}
+	}
+	e.hashes = append(e.hashes, hash)
+	if len(e.hashes) > e.p.MaxPeriod {
+		e.hashes = e.hashes[1:]
+	}
+}
+
+// skipTo jumps to a later turn with the same world, dropping the flips log so that streamers
+// resynchronise.
+func (e *localEngine) skipTo(turn int) {
+	fmt.Printf("Skipping from turn %v to turn %v\n", e.turn, turn)
+	e.turn = turn
+	e.flips = nil
+	e.flipsFrom = turn
+}
+
+func (e *localEngine) State() (*Response, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return &Response{FinalBoard: e.world, Turn: e.turn, Paused: e.paused, P: e.p}, nil
+}
+
+func (e *localEngine) AliveCells() (*TickerResponse, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	res := &TickerResponse{AliveCells: e.world.AliveCells(), Turn: e.turn}
+	if e.cyclePeriod != 0 && !e.cycleReported {
+		res.CycleTurn = e.cycleTurn
+		res.CyclePeriod = e.cyclePeriod
+		e.cycleReported = true
+	}
+	return res, nil
+}
+
+func (e *localEngine) Flips(turn int) (*FlipsResponse, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	res := new(FlipsResponse)
+	if turn >= e.flipsFrom && turn <= e.flipsFrom+len(e.flips) {
+		res.Turns = append(res.Turns, e.flips[turn-e.flipsFrom:]...)
+		res.Turn = e.flipsFrom + len(e.flips)
+		return res, nil
+	}
+	// The streamer changes the world it is given, so it gets its own copy.
+	res.Resync = true
+	res.World = copyBoard(e.world)
+	res.Turn = e.turn
+	return res, nil
+}
+
+func (e *localEngine) Pause() error {
+	e.mutex.Lock()
+	e.paused = !e.paused
+	paused := e.paused
+	if !paused {
+		e.finishSteps()
+	}
+	e.mutex.Unlock()
+	if !paused {
+		e.signal()
+	}
+	return nil
+}
+
+func (e *localEngine) Advance(turns int) (*Response, error) {
+	if turns < 1 {
+		return nil, fmt.Errorf("cannot advance by %v turns", turns)
+	}
+	e.mutex.Lock()
+	if e.stopped != nil || e.turn >= e.p.Turns {
+		e.mutex.Unlock()
+		return nil, errors.New("the simulation is not running")
+	}
+	if !e.paused {
+		e.mutex.Unlock()
+		return nil, errors.New("the simulation must be paused to advance it")
+	}
+	if e.stepDone != nil {
+		e.mutex.Unlock()
+		return nil, errors.New("the simulation is already advancing")
+	}
+	stepped := make(chan struct{})
+	e.stepBudget = turns
+	e.stepDone = stepped
+	e.mutex.Unlock()
+	e.signal()
+
+	<-stepped
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return &Response{Turn: e.turn}, nil
+}
+
+func (e *localEngine) Quit() error {
+	e.stop(&Response{Quit: true})
+	return nil
+}
+
+func (e *localEngine) Detach() error {
+	return errors.New("the local engine cannot run without a client, so it cannot be detached from")
+}
+
+func (e *localEngine) Terminate() error {
+	e.stop(&Response{Terminated: true})
+	return nil
+}
+
+func (e *localEngine) Close() error {
+	return nil
+}
+
+// stop ends the simulation, waking Evolve if it is paused.
+func (e *localEngine) stop(res *Response) {
+	e.mutex.Lock()
+	if e.stopped == nil {
+		e.stopped = res
+	}
+	e.mutex.Unlock()
+	e.signal()
+}
+
+// finishSteps lets Advance return, whether or not all the turns it asked for have been run. It
+// is called with the mutex held.
+func (e *localEngine) finishSteps() {
+	e.stepBudget = 0
+	if e.stepDone != nil {
+		close(e.stepDone)
+		e.stepDone = nil
+	}
+}
+
+// signal wakes Evolve if it is waiting while paused.
+func (e *localEngine) signal() {
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,114 @@
+package gol
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Patterns are files that hold a few cells to be placed on an otherwise dead board, rather
+// than images of the whole world. They are told apart by their extension.
+
+// pattern is a pattern read from a file.
+type pattern struct {
+	width, height int
+	rule          string  // empty if the file gives none
+	states        [][]int // states[y][x]
+
+	// Formats that list the coordinates of cells rather than draw them place the pattern by the
+	// cell at 0,0, which is at originX, originY in states, instead of by its top left.
+	hasOrigin        bool
+	originX, originY int
+}
+
+// patternReaders read each pattern format, by extension, for a board of the given size.
+var patternReaders = map[string]func(r *bufio.Reader, boardWidth, boardHeight int) (*pattern, error){
+	".rle":   readRLE,
+	".cells": readPlaintext,
+	".lif":   readLife106,
+	".life":  readLife106,
+}
+
+// IsPattern reports whether a file holds a pattern rather than an image of the whole world.
+func IsPattern(path string) bool {
+	_, ok := patternReaders[strings.ToLower(filepath.Ext(path))]
+	return ok
+}
+
+// allocate makes states for a pattern of the pattern's size with every cell dead. A pattern
+// larger than the board it is read for is a DimensionsError, found before any room is made for
+// it, as the size comes from the file and may be far too large to allocate.
+func (pat *pattern) allocate(boardWidth, boardHeight int) error {
+	if pat.width > boardWidth || pat.height > boardHeight {
+		return patternTooLarge(fmt.Sprintf("a %vx%v pattern", pat.width, pat.height), boardWidth, boardHeight)
+	}
+	pat.states = make([][]int, pat.height)
+	for y := range pat.states {
+		pat.states[y] = make([]int, pat.width)
+	}
+	return nil
+}
+
+// patternTooLarge is the error for a pattern, as described, that is larger than the board.
+func patternTooLarge(pattern string, boardWidth, boardHeight int) error {
+	return &DimensionsError{boardWidth, boardHeight, fmt.Errorf("%v does not fit on a %vx%v board", pattern, boardWidth, boardHeight)}
+}
+
+// patternOffset returns where the top left of a pattern goes on the board. It is p.Offset if
+// set, otherwise wherever puts the pattern in the middle. A pattern with an origin instead has
+// its origin at p.Offset, or in the middle of the board.
+func patternOffset(p Params, pat *pattern) (int, int, error) {
+	x, y := (p.ImageWidth-pat.width)/2, (p.ImageHeight-pat.height)/2
+	if pat.hasOrigin {
+		x, y = p.ImageWidth/2-pat.originX, p.ImageHeight/2-pat.originY
+	}
+	if p.Offset != nil {
+		x, y = p.Offset.X, p.Offset.Y
+		if pat.hasOrigin {
+			x, y = x-pat.originX, y-pat.originY
+		}
+	}
+	if x < 0 || y < 0 || x+pat.width > p.ImageWidth || y+pat.height > p.ImageHeight {
+		return 0, 0, &DimensionsError{p.ImageWidth, p.ImageHeight, fmt.Errorf("a %vx%v pattern at %v,%v does not fit on a %vx%v board",
+			pat.width, pat.height, x, y, p.ImageWidth, p.ImageHeight)}
+	}
+	return x, y, nil
+}
+
+// readPattern reads a pattern file, placing it on a board of the size in p, and calls pixel with
+// the grey level of each cell of the board.
+func readPattern(path string, p Params, pixel func(grey uint8)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	read := patternReaders[strings.ToLower(filepath.Ext(path))]
+	pat, err := read(bufio.NewReader(file), p.ImageWidth, p.ImageHeight)
+	if err != nil {
+		return fmt.Errorf("%v: %w", path, err)
+	}
+	left, top, err := patternOffset(p, pat)
+	if err != nil {
+		return fmt.Errorf("%v: %w", path, err)
+	}
+	rule, err := paramsRule(p)
+	if err != nil {
+		return err
+	}
+	for y := 0; y < p.ImageHeight; y++ {
+		for x := 0; x < p.ImageWidth; x++ {
+			state := 0
+			if x >= left && x < left+pat.width && y >= top && y < top+pat.height {
+				state = pat.states[y-top][x-left]
+			}
+			if state >= rule.States {
+				return fmt.Errorf("%v: state %v at %v,%v is not a state of %v", path, state, x-left, y-top, rule)
+			}
+			pixel(rule.Grey(state))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package gol
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// parseColour reads a colour in the form #rrggbb, with or without the '#'. An empty string is
+// the given default.
+func parseColour(s string, def color.RGBA) (color.RGBA, error) {
+	if s == "" {
+		return def, nil
+	}
+	hex := strings.TrimPrefix(s, "#")
+	value, err := strconv.ParseUint(hex, 16, 32)
+	if len(hex) != 6 || err != nil {
+		return color.RGBA{}, fmt.Errorf("colour %q: want the form #rrggbb", s)
+	}
+	return color.RGBA{R: uint8(value >> 16), G: uint8(value >> 8), B: uint8(value), A: 255}, nil
+}
+
+// pngPalette returns the colour of each grey level: the dead colour for black, the alive colour
+// for white, and colours in between for the greys of dying cells.
+func pngPalette(p Params) (color.Palette, error) {
+	alive, err := parseColour(p.AliveColour, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+	if err != nil {
+		return nil, err
+	}
+	dead, err := parseColour(p.DeadColour, color.RGBA{A: 255})
+	if err != nil {
+		return nil, err
+	}
+	mix := func(from, to uint8, grey int) uint8 {
+		return uint8((int(from)*(255-grey) + int(to)*grey + 127) / 255)
+	}
+	palette := make(color.Palette, 256)
+	for grey := range palette {
+		palette[grey] = color.RGBA{
+			R: mix(dead.R, alive.R, grey),
+			G: mix(dead.G, alive.G, grey),
+			B: mix(dead.B, alive.B, grey),
+			A: 255,
+		}
+	}
+	return palette, nil
+}
+
+// pngScale is the number of pixels along each side of a cell in a png.
+func pngScale(p Params) int {
+	if p.Scale < 1 {
+		return 1
+	}
+	return p.Scale
+}
+
+// writePng writes a board of grey levels as a png, coloured with the palette and with each cell
+// drawn as a square of scale by scale pixels.
+func writePng(w io.Writer, width, height, scale int, palette color.Palette, grey func(x, y int) uint8) error {
+	img := image.NewPaletted(image.Rect(0, 0, width*scale, height*scale), palette)
+	for y := 0; y < height*scale; y++ {
+		row := img.Pix[y*img.Stride : y*img.Stride+width*scale]
+		if y%scale != 0 {
+			copy(row, img.Pix[(y-1)*img.Stride:])
+			continue
+		}
+		for x := range row {
+			row[x] = grey(x/scale, y/scale)
+		}
+	}
+	return png.Encode(w, img)
+}
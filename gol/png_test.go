@@ -0,0 +1,80 @@
+package gol
+
+import (
+	"context"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestPngOutput checks that a png is written in the alive and dead colours asked for, with each
+// cell drawn as a square of Scale pixels.
+func TestPngOutput(t *testing.T) {
+	// A blinker in the middle of a 5x3 world, which is vertical after one turn.
+	path := writeTemp(t, "blinker.pgm", []byte("P2 5 3 255\n0 0 0 0 0\n0 255 255 255 0\n0 0 0 0 0\n"))
+	dir := t.TempDir()
+	p := Params{Turns: 1, Threads: 1, Input: path, OutputDir: dir, Engine: "local",
+		Format: "png", AliveColour: "#ff8000", DeadColour: "102030", Scale: 3}
+	events := make(chan Event, 1000)
+	if err := RunContext(context.Background(), p, events, nil); err != nil {
+		t.Fatal(err)
+	}
+	for range events {
+	}
+
+	file, err := os.Open(filepath.Join(dir, "5x3x1.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	img, err := png.Decode(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := img.Bounds().Size(); size.X != 15 || size.Y != 9 {
+		t.Fatalf("png is %vx%v, want 15x9", size.X, size.Y)
+	}
+	alive := color.RGBA{R: 0xff, G: 0x80, B: 0x00, A: 255}
+	dead := color.RGBA{R: 0x10, G: 0x20, B: 0x30, A: 255}
+	for y := 0; y < 9; y++ {
+		for x := 0; x < 15; x++ {
+			want := dead
+			if x/3 == 2 {
+				want = alive
+			}
+			if got := color.RGBAModel.Convert(img.At(x, y)); got != want {
+				t.Fatalf("pixel (%v, %v) is %v, want %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+// TestPngPalette checks that the greys of dying cells are coloured between the dead and alive
+// colours, and that colours not in the form #rrggbb are refused.
+func TestPngPalette(t *testing.T) {
+	palette, err := pngPalette(Params{AliveColour: "#ff8000", DeadColour: "#0080ff"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		grey int
+		want color.RGBA
+	}{
+		{0, color.RGBA{R: 0x00, G: 0x80, B: 0xff, A: 255}},
+		{128, color.RGBA{R: 0x80, G: 0x80, B: 0x7f, A: 255}},
+		{255, color.RGBA{R: 0xff, G: 0x80, B: 0x00, A: 255}},
+	}
+	for _, test := range tests {
+		if got := palette[test.grey]; got != test.want {
+			t.Errorf("grey %v is %v, want %v", test.grey, got, test.want)
+		}
+	}
+
+	for _, colour := range []string{"#fff", "#1234567", "#gg0000", "red"} {
+		if err := CheckOutput(Params{AliveColour: colour}); err == nil {
+			t.Errorf("alive colour %q accepted", colour)
+		}
+	}
+}
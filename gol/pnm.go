@@ -0,0 +1,274 @@
+package gol
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// The netpbm formats read here are P1 and P4, ASCII and binary bitmaps, and P2 and P5, ASCII
+// and binary greymaps. Headers may contain comments from a '#' to the end of the line.
+// In a bitmap a set bit (black) is an alive cell. A grey level is scaled from 0..maxval to
+// 0..255, and then read as a state by the rule, so with two states any pixel at least half
+// way to white is alive.
+
+// pnmHeader is the header of a netpbm image.
+type pnmHeader struct {
+	magic  string
+	width  int
+	height int
+	maxval int // 1 for bitmaps
+}
+
+// pnmReader reads a netpbm image a byte at a time, keeping track of the line for errors.
+type pnmReader struct {
+	r    *bufio.Reader
+	line int
+}
+
+func newPnmReader(r io.Reader) *pnmReader {
+	return &pnmReader{r: bufio.NewReader(r), line: 1}
+}
+
+func (p *pnmReader) readByte() (byte, error) {
+	b, err := p.r.ReadByte()
+	if b == '\n' {
+		p.line++
+	}
+	return b, err
+}
+
+func (p *pnmReader) unreadByte() {
+	_ = p.r.UnreadByte()
+	if b, _ := p.r.Peek(1); len(b) == 1 && b[0] == '\n' {
+		p.line--
+	}
+}
+
+func isPnmSpace(b byte) bool {
+	switch b {
+	case ' ', '\t', '\n', '\r', '\v', '\f':
+		return true
+	}
+	return false
+}
+
+// skipSpace skips whitespace and comments, returning io.ErrUnexpectedEOF at the end of the file.
+func (p *pnmReader) skipSpace() error {
+	for {
+		b, err := p.readByte()
+		if err != nil {
+			return noEOF(err)
+		}
+		switch {
+		case b == '#':
+			for b != '\n' {
+				b, err = p.readByte()
+				if err != nil {
+					return noEOF(err)
+				}
+			}
+		case !isPnmSpace(b):
+			p.unreadByte()
+			return nil
+		}
+	}
+}
+
+// token reads the next whitespace separated token, skipping comments.
+func (p *pnmReader) token() (string, error) {
+	if err := p.skipSpace(); err != nil {
+		return "", err
+	}
+	var token []byte
+	for {
+		b, err := p.readByte()
+		if err == io.EOF {
+			return string(token), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if isPnmSpace(b) || b == '#' {
+			p.unreadByte()
+			return string(token), nil
+		}
+		token = append(token, b)
+	}
+}
+
+// number reads the next token as a number of at least min and at most max.
+func (p *pnmReader) number(name string, min, max int) (int, error) {
+	token, err := p.token()
+	if err != nil {
+		return 0, fmt.Errorf("reading %v: %w", name, err)
+	}
+	n, err := strconv.Atoi(token)
+	if err != nil {
+		return 0, fmt.Errorf("line %v: %v %q is not a number", p.line, name, token)
+	}
+	if n < min || n > max {
+		return 0, fmt.Errorf("line %v: %v %v is not between %v and %v", p.line, name, n, min, max)
+	}
+	return n, nil
+}
+
+// header reads the header, leaving the reader at the first byte of the raster.
+func (p *pnmReader) header() (pnmHeader, error) {
+	var h pnmHeader
+	magic := make([]byte, 2)
+	if _, err := io.ReadFull(p.r, magic); err != nil {
+		return h, errors.New("empty file")
+	}
+	h.magic = string(magic)
+	switch h.magic {
+	case "P1", "P2", "P4", "P5":
+	case "P3", "P6":
+		return h, fmt.Errorf("colour images (%v) are not supported, convert to greyscale first", h.magic)
+	default:
+		return h, fmt.Errorf("not a pbm or pgm image (starts %q)", h.magic)
+	}
+
+	var err error
+	if h.width, err = p.number("width", 1, 1<<24); err != nil {
+		return h, err
+	}
+	if h.height, err = p.number("height", 1, 1<<24); err != nil {
+		return h, err
+	}
+	h.maxval = 1
+	if h.magic == "P2" || h.magic == "P5" {
+		if h.maxval, err = p.number("maxval", 1, 65535); err != nil {
+			return h, err
+		}
+	}
+	if h.magic == "P4" || h.magic == "P5" {
+		// A single whitespace byte separates the header from the binary raster.
+		b, err := p.readByte()
+		if err != nil || !isPnmSpace(b) {
+			return h, fmt.Errorf("line %v: no whitespace after the header", p.line)
+		}
+	}
+	return h, nil
+}
+
+// pixels reads the raster, calling pixel with the grey level of each pixel in turn from 0 for
+// black to 255 for white, except that bitmap pixels are 255 if set.
+func (p *pnmReader) pixels(h pnmHeader, pixel func(grey uint8)) error {
+	total := h.width * h.height
+	for i := 0; i < total; {
+		var err error
+		switch h.magic {
+		case "P1":
+			err = p.skipSpace()
+			var b byte
+			if err == nil {
+				b, err = p.readByte()
+			}
+			if err == nil && b != '0' && b != '1' {
+				err = fmt.Errorf("line %v: %q is not a bit", p.line, b)
+			}
+			if err == nil {
+				pixel(255 * (b - '0'))
+				i++
+			}
+		case "P4":
+			// Rows are packed eight pixels to a byte, most significant bit first, and padded to a byte.
+			n := h.width - i%h.width
+			if n > 8 {
+				n = 8
+			}
+			var b byte
+			b, err = p.readByte()
+			for bit := 0; err == nil && bit < n; bit++ {
+				pixel(255 * (b >> uint(7-bit) & 1))
+			}
+			if err == nil {
+				i += n
+			}
+		case "P2":
+			var value int
+			value, err = p.number("pixel", 0, h.maxval)
+			if err == nil {
+				pixel(scaleGrey(value, h.maxval))
+				i++
+			}
+		case "P5":
+			var value int
+			value, err = p.sample(h.maxval)
+			if err == nil {
+				pixel(scaleGrey(value, h.maxval))
+				i++
+			}
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return fmt.Errorf("the image ends after %v of its %v pixels", i, total)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// sample reads a binary grey level, which takes two bytes, most significant first,
+// if maxval is over 255.
+func (p *pnmReader) sample(maxval int) (int, error) {
+	hi, err := p.readByte()
+	if err != nil || maxval < 256 {
+		return int(hi), noEOF(err)
+	}
+	lo, err := p.readByte()
+	return int(hi)<<8 | int(lo), noEOF(err)
+}
+
+// noEOF turns the end of the file into an unexpected end, as it only happens part way through.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// scaleGrey scales a grey level from 0..maxval to 0..255.
+func scaleGrey(value, maxval int) uint8 {
+	return uint8((value*255 + maxval/2) / maxval)
+}
+
+// ReadImageSize returns the width and height given in the header of a pbm or pgm file.
+func ReadImageSize(path string) (width, height int, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+	h, err := newPnmReader(file).header()
+	if err != nil {
+		return 0, 0, fmt.Errorf("%v: %v", path, err)
+	}
+	return h.width, h.height, nil
+}
+
+// readPnm reads a pbm or pgm file of the given size, calling pixel with the grey level of each pixel.
+func readPnm(path string, width, height int, pixel func(grey uint8)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	r := newPnmReader(file)
+	h, err := r.header()
+	if err == nil && (h.width != width || h.height != height) {
+		return &DimensionsError{width, height, fmt.Errorf("%v: the image is %vx%v, not %vx%v", path, h.width, h.height, width, height)}
+	}
+	if err == nil {
+		err = r.pixels(h, pixel)
+	}
+	if err != nil {
+		return fmt.Errorf("%v: %v", path, err)
+	}
+	return nil
+}
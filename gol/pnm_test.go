@@ -0,0 +1,124 @@
+package gol
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTemp writes data to a file in a directory removed after the test, returning its path.
+func writeTemp(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestReadPnm reads small images in each netpbm format, and truncated or malformed ones.
+func TestReadPnm(t *testing.T) {
+	tests := []struct {
+		name          string
+		data          string
+		width, height int
+		want          []uint8
+		wantErr       string
+	}{
+		{
+			name:  "P1 with comments",
+			data:  "P1\n# a comment\n3 # width\n2\n1 0 1\n#another\n0 1 0\n",
+			width: 3, height: 2,
+			want: []uint8{255, 0, 255, 0, 255, 0},
+		},
+		{
+			name:  "P1 without spaces",
+			data:  "P1 3 2 101010",
+			width: 3, height: 2,
+			want: []uint8{255, 0, 255, 0, 255, 0},
+		},
+		{
+			name:  "P2",
+			data:  "P2\n2 2\n4\n0 1\n2 4\n",
+			width: 2, height: 2,
+			want: []uint8{0, 64, 128, 255},
+		},
+		{
+			name:  "P2 maxval 65535",
+			data:  "P2 3 1 65535 0 32768 65535",
+			width: 3, height: 1,
+			want: []uint8{0, 128, 255},
+		},
+		{
+			// Each row of ten pixels takes two bytes, the second padded with zero bits.
+			name:  "P4",
+			data:  "P4\n# packed\n10 2\n\xa5\xc0\x00\x40",
+			width: 10, height: 2,
+			want: []uint8{255, 0, 255, 0, 0, 255, 0, 255, 255, 255, 0, 0, 0, 0, 0, 0, 0, 0, 0, 255},
+		},
+		{
+			name:  "P5",
+			data:  "P5 # binary\n3 1 255\n\x00\x80\xff",
+			width: 3, height: 1,
+			want: []uint8{0, 128, 255},
+		},
+		{
+			name:  "P5 maxval 65535",
+			data:  "P5\n3 1\n65535\n\x00\x00\x80\x00\xff\xff",
+			width: 3, height: 1,
+			want: []uint8{0, 128, 255},
+		},
+		{name: "truncated P1", data: "P1\n3 2\n1 0 1\n0", width: 3, height: 2, wantErr: "ends after 4 of its 6 pixels"},
+		{name: "truncated P2", data: "P2\n2 2\n255\n0 1\n", width: 2, height: 2, wantErr: "ends after 2 of its 4 pixels"},
+		{name: "truncated P4", data: "P4\n10 2\n\xa5\xc0", width: 10, height: 2, wantErr: "ends after 10 of its 20 pixels"},
+		{name: "truncated P5 maxval 65535", data: "P5\n2 1\n65535\n\x00\x00\x80", width: 2, height: 1, wantErr: "ends after 1 of its 2 pixels"},
+		{name: "truncated header", data: "P2\n2 2\n", width: 2, height: 2, wantErr: "reading maxval"},
+		{name: "empty", data: "", width: 2, height: 2, wantErr: "empty file"},
+		{name: "pixel over maxval", data: "P2 2 1 3 0 4", width: 2, height: 1, wantErr: "pixel 4 is not between 0 and 3"},
+		{name: "bad bit", data: "P1 2 1 0 2", width: 2, height: 1, wantErr: "'2' is not a bit"},
+		{name: "bad width", data: "P1 x 1 0", width: 1, height: 1, wantErr: `width "x" is not a number`},
+		{name: "no space after header", data: "P5 1 1 255#\x00", width: 1, height: 1, wantErr: "no whitespace after the header"},
+		{name: "colour", data: "P6 1 1 255\n\x00\x00\x00", width: 1, height: 1, wantErr: "colour images"},
+		{name: "not netpbm", data: "GIF89a", width: 1, height: 1, wantErr: "not a pbm or pgm image"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := writeTemp(t, "image.pnm", []byte(test.data))
+			var got []uint8
+			err := readPnm(path, test.width, test.height, func(grey uint8) {
+				got = append(got, grey)
+			})
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("error %v, want one containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != string(test.want) {
+				t.Errorf("pixels %v, want %v", got, test.want)
+			}
+			width, height, err := ReadImageSize(path)
+			if err != nil || width != test.width || height != test.height {
+				t.Errorf("ReadImageSize = %v, %v, %v, want %v, %v", width, height, err, test.width, test.height)
+			}
+		})
+	}
+}
+
+// TestReadPnmDimensions checks that an image of the wrong size is a DimensionsError.
+func TestReadPnmDimensions(t *testing.T) {
+	path := writeTemp(t, "image.pgm", []byte("P2 2 1 255 0 0"))
+	err := readPnm(path, 2, 2, func(uint8) {})
+	var dimensionsErr *DimensionsError
+	if !errors.As(err, &dimensionsErr) {
+		t.Fatalf("error %v, want a DimensionsError", err)
+	}
+	if dimensionsErr.Width != 2 || dimensionsErr.Height != 2 {
+		t.Errorf("DimensionsError is for %vx%v, want 2x2", dimensionsErr.Width, dimensionsErr.Height)
+	}
+}
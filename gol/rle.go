@@ -0,0 +1,216 @@
+package gol
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// RLE is the run length encoded pattern format of Golly and the LifeWiki. A header line such as
+// "x = 3, y = 3, rule = B3/S23" gives the size of the pattern, and the cells follow as runs such
+// as "2bo" for two dead cells then an alive one, with '$' ending a row and '!' the pattern.
+// With two states any letter other than 'b' is alive. With more, '.' is dead and 'A' to 'X' are
+// states 1 to 24, with 'p' to 'y' in front for higher ones.
+
+// PatternRule returns the rule given in the header of an RLE file, or an empty string if there
+// is none or the file is not RLE.
+func PatternRule(path string) (string, error) {
+	if !strings.EqualFold(filepath.Ext(path), ".rle") {
+		return "", nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	pat, err := readRLEHeader(bufio.NewReader(file))
+	if err != nil {
+		return "", fmt.Errorf("%v: %v", path, err)
+	}
+	return pat.rule, nil
+}
+
+// readRLEHeader skips the comment lines at the start of an RLE file and reads the header line.
+func readRLEHeader(r *bufio.Reader) (*pattern, error) {
+	pat := new(pattern)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return pat, fmt.Errorf("no x = , y = header line")
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key := ""
+		for _, field := range strings.Split(line, ",") {
+			parts := strings.SplitN(field, "=", 2)
+			if len(parts) != 2 && key == "rule" {
+				// The rest of a bounded grid such as ":T64,64".
+				continue
+			}
+			if len(parts) != 2 {
+				return pat, fmt.Errorf("header %q: want the form x = 3, y = 3, rule = B3/S23", line)
+			}
+			var value string
+			key, value = strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+			switch key {
+			case "x", "y":
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 0 {
+					return pat, fmt.Errorf("header %q: %v = %q is not a size", line, key, value)
+				}
+				if key == "x" {
+					pat.width = n
+				} else {
+					pat.height = n
+				}
+			case "rule":
+				// Golly may add a bounded grid such as ":T64,64", which is left to -topology.
+				pat.rule = strings.SplitN(value, ":", 2)[0]
+			}
+		}
+		return pat, nil
+	}
+}
+
+// readRLE reads an RLE file for a board of the given size.
+func readRLE(r *bufio.Reader, boardWidth, boardHeight int) (*pattern, error) {
+	pat, err := readRLEHeader(r)
+	if err == nil {
+		err = pat.allocate(boardWidth, boardHeight)
+	}
+	if err == nil {
+		err = pat.readRLECells(r)
+	}
+	return pat, err
+}
+
+// readRLECells reads the runs of cells after the header into the allocated states.
+func (pat *pattern) readRLECells(r *bufio.Reader) error {
+	x, y, count, prefix := 0, 0, 0, 0
+	for {
+		c, _, err := r.ReadRune()
+		if err == io.EOF {
+			// Some files leave off the final '!'.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		state := -1
+		switch {
+		case unicode.IsSpace(c):
+			continue
+		case c >= '0' && c <= '9':
+			count = count*10 + int(c-'0')
+			continue
+		case c == '!':
+			return nil
+		case c == '$':
+			y += runLength(count)
+			x, count = 0, 0
+			continue
+		case c >= 'p' && c <= 'y':
+			prefix = int(c-'p'+1) * 24
+			continue
+		case c == 'b' || c == '.':
+			state = 0
+		case c >= 'A' && c <= 'X':
+			state = prefix + int(c-'A'+1)
+		case unicode.IsLetter(c):
+			state = 1
+		default:
+			return fmt.Errorf("unexpected %q in the cells", c)
+		}
+		run := runLength(count)
+		if state != 0 {
+			if y >= pat.height || x+run > pat.width {
+				return fmt.Errorf("cells beyond the %vx%v given in the header", pat.width, pat.height)
+			}
+			for i := 0; i < run; i++ {
+				pat.states[y][x+i] = state
+			}
+		}
+		x += run
+		count, prefix = 0, 0
+	}
+}
+
+// runLength is the length of a run with the given count in front, which is 1 if left out.
+func runLength(count int) int {
+	if count == 0 {
+		return 1
+	}
+	return count
+}
+
+// writeRLE writes a board of cell states as RLE, keeping lines under 70 characters.
+func writeRLE(w io.Writer, width, height int, rule util.Rule, state func(x, y int) int) error {
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "x = %v, y = %v, rule = %v\n", width, height, rule)
+
+	lineLength := 0
+	emit := func(count int, tag string) {
+		run := tag
+		if count > 1 {
+			run = strconv.Itoa(count) + tag
+		}
+		if lineLength+len(run) > 70 {
+			out.WriteString("\n")
+			lineLength = 0
+		}
+		out.WriteString(run)
+		lineLength += len(run)
+	}
+	tag := func(s int) string {
+		switch {
+		case rule.States <= 2 && s == 0:
+			return "b"
+		case rule.States <= 2:
+			return "o"
+		case s == 0:
+			return "."
+		case s <= 24:
+			return string(rune('A' + s - 1))
+		default:
+			return string(rune('p'+(s-1)/24-1)) + string(rune('A'+(s-1)%24))
+		}
+	}
+
+	// Dead cells at the ends of rows and empty rows at the bottom are left out.
+	endRows := 0
+	for y := 0; y < height; y++ {
+		run, runState := 0, 0
+		for x := 0; x < width; x++ {
+			s := state(x, y)
+			if s != runState && run > 0 {
+				if endRows > 0 {
+					emit(endRows, "$")
+					endRows = 0
+				}
+				emit(run, tag(runState))
+				run = 0
+			}
+			runState = s
+			run++
+		}
+		if runState != 0 {
+			if endRows > 0 {
+				emit(endRows, "$")
+				endRows = 0
+			}
+			emit(run, tag(runState))
+		}
+		endRows++
+	}
+	out.WriteString("!\n")
+	return out.Flush()
+}
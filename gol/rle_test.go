@@ -0,0 +1,171 @@
+package gol
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// randomStates returns a board of random states, mostly dead, with the last row and column left
+// dead to check they are kept when they are left out of a file.
+func randomStates(seed int64, width, height, states int) [][]int {
+	rng := rand.New(rand.NewSource(seed))
+	board := make([][]int, height)
+	for y := range board {
+		board[y] = make([]int, width)
+		for x := 0; x < width-1 && y < height-1; x++ {
+			if rng.Intn(3) == 0 {
+				board[y][x] = 1 + rng.Intn(states-1)
+			}
+		}
+	}
+	return board
+}
+
+// statesDiffer describes the first cell in which a pattern differs from a board of states, or
+// returns "" if they agree.
+func statesDiffer(pat *pattern, want [][]int) string {
+	if pat.height != len(want) || pat.width != len(want[0]) {
+		return fmt.Sprintf("pattern is %vx%v, want %vx%v", pat.width, pat.height, len(want[0]), len(want))
+	}
+	for y := range want {
+		for x := range want[y] {
+			if pat.states[y][x] != want[y][x] {
+				return fmt.Sprintf("cell %v,%v has state %v, want %v", x, y, pat.states[y][x], want[y][x])
+			}
+		}
+	}
+	return ""
+}
+
+// TestRLERoundTrip writes boards as RLE and reads them back, under rules with two states, a few
+// states and more than the 24 that can be written with a single letter.
+func TestRLERoundTrip(t *testing.T) {
+	for _, ruleName := range []string{"B3/S23", "B36/S23", "/2/3", "B2/S345/C4", "B2/S/C60"} {
+		rule, err := util.ParseRule(ruleName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, size := range [][2]int{{1, 1}, {3, 3}, {100, 7}, {40, 40}} {
+			width, height := size[0], size[1]
+			t.Run(fmt.Sprintf("%v-%dx%d", rule, width, height), func(t *testing.T) {
+				want := randomStates(int64(width*height), width, height, rule.States)
+				var out bytes.Buffer
+				err := writeRLE(&out, width, height, rule, func(x, y int) int { return want[y][x] })
+				if err != nil {
+					t.Fatal(err)
+				}
+				for i, line := range strings.Split(out.String(), "\n") {
+					if len(line) > 70 {
+						t.Errorf("line %v is %v characters long", i+1, len(line))
+					}
+				}
+
+				pat, err := readRLE(bufio.NewReader(&out), width, height)
+				if err != nil {
+					t.Fatalf("reading back %q: %v", out.String(), err)
+				}
+				if pat.rule != rule.String() {
+					t.Errorf("rule %q, want %q", pat.rule, rule)
+				}
+				if diff := statesDiffer(pat, want); diff != "" {
+					t.Errorf("%v in %q", diff, out.String())
+				}
+			})
+		}
+	}
+}
+
+// TestReadRLE reads RLE as other programs write it: with comments, a Golly bounded grid, any
+// letter for alive cells, runs split across lines and no final '!'.
+func TestReadRLE(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		wantRule string
+		want     [][]int
+		wantErr  string
+	}{
+		{
+			name:     "glider",
+			data:     "#N Glider\n#C A comment\nx = 3, y = 3, rule = B3/S23\nbob$2bo$3o!\n",
+			wantRule: "B3/S23",
+			want:     [][]int{{0, 1, 0}, {0, 0, 1}, {1, 1, 1}},
+		},
+		{
+			name:     "bounded grid",
+			data:     "x = 2, y = 1, rule = B36/S23:T64,64\n2o!",
+			wantRule: "B36/S23",
+			want:     [][]int{{1, 1}},
+		},
+		{
+			name: "no rule, any letter and a run across lines",
+			data: "x=4,y=3\n\nb2\nz$$\n4o",
+			want: [][]int{{0, 1, 1, 0}, {0, 0, 0, 0}, {1, 1, 1, 1}},
+		},
+		{
+			name:     "generations",
+			data:     "x = 4, y = 2, rule = B2/S/C30\n.A2B$pDpA!",
+			wantRule: "B2/S/C30",
+			want:     [][]int{{0, 1, 2, 2}, {28, 25, 0, 0}},
+		},
+		{name: "no header", data: "#C only a comment\n", wantErr: "no x = , y = header line"},
+		{name: "bad size", data: "x = a, y = 1\no!", wantErr: `x = "a" is not a size`},
+		{name: "larger than the board", data: "x = 200000, y = 200000\no!", wantErr: "a 200000x200000 pattern does not fit on a 16x16 board"},
+		{name: "beyond header", data: "x = 2, y = 1\n3o!", wantErr: "cells beyond the 2x1 given in the header"},
+		{name: "bad character", data: "x = 2, y = 1\no?!", wantErr: "unexpected '?'"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pat, err := readRLE(bufio.NewReader(strings.NewReader(test.data)), 16, 16)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("error %v, want one containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pat.rule != test.wantRule {
+				t.Errorf("rule %q, want %q", pat.rule, test.wantRule)
+			}
+			if diff := statesDiffer(pat, test.want); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+// TestReadRLETooLarge checks that a header larger than the board is a DimensionsError, found
+// without making room for the cells it claims, whether or not any cells follow.
+func TestReadRLETooLarge(t *testing.T) {
+	for _, data := range []string{"x = 200000, y = 200000\n", "x = 17, y = 1\n17o!", "x = 1, y = 200000\no!"} {
+		path := writeTemp(t, "large.rle", []byte(data))
+		err := readPattern(path, Params{ImageWidth: 16, ImageHeight: 16}, func(uint8) {})
+		var dimensionsErr *DimensionsError
+		if !errors.As(err, &dimensionsErr) {
+			t.Errorf("reading %q: error %v, want a DimensionsError", data, err)
+		}
+	}
+}
+
+// TestPatternRule checks that the rule is read from the header of an RLE file, and only from one.
+func TestPatternRule(t *testing.T) {
+	for _, test := range []struct{ name, data, want string }{
+		{"glider.rle", "#C comment\nx = 3, y = 3, rule = B36/S23\nbob$2bo$3o!", "B36/S23"},
+		{"GLIDER.RLE", "x = 3, y = 3, rule = /2/3\nbob$2bo$3o!", "/2/3"},
+		{"glider.cells", "x = 3, y = 3, rule = B36/S23\n", ""},
+	} {
+		rule, err := PatternRule(writeTemp(t, test.name, []byte(test.data)))
+		if err != nil || rule != test.want {
+			t.Errorf("PatternRule of %v = %q, %v, want %q", test.name, rule, err, test.want)
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 
 	"uk.ac.bris.cs/gameoflife/gol"
 	"uk.ac.bris.cs/gameoflife/sdl"
+	"uk.ac.bris.cs/gameoflife/util"
 )
 
 // main is the function called when starting Game of Life with 'go run .'
@@ -41,6 +42,95 @@ func main() {
 		10000000000,
 		"Specify the number of turns to process. Defaults to 10000000000.")
 
+	flag.StringVar(
+		&params.Rule,
+		"rule",
+		"B3/S23",
+		"Specify the rule in B/S notation, e.g. B36/S23, B/S/C notation for a Generations rule, e.g. B2/S/C3, or a name such as highlife. Defaults to Conway's B3/S23.")
+
+	flag.StringVar(
+		&params.Topology,
+		"topology",
+		"torus",
+		"Specify how the edges of the world are joined: torus, plane, cylinder or klein. Defaults to torus.")
+
+	flag.StringVar(
+		&params.Algorithm,
+		"algorithm",
+		"strips",
+		"Specify how the broker runs the world: strips to share it between the servers, or hashlife for very long runs of square, power of two sized tori. Defaults to strips.")
+
+	flag.IntVar(
+		&params.MaxPeriod,
+		"maxPeriod",
+		0,
+		"Specify the longest period of cycle to look for, e.g. 16, which hashes the world every turn. Defaults to 0, to not look.")
+
+	flag.BoolVar(
+		&params.StopOnCycle,
+		"stopOnCycle",
+		false,
+		"Skip ahead to the last turn once the world is found to repeat. Needs -maxPeriod.")
+
+	flag.BoolVar(
+		&params.Attach,
+		"attach",
+		false,
+		"Attach to a simulation already running on the broker, chosen with -session. Its size is taken from the broker; -w and -h, if given, must match it.")
+
+	flag.StringVar(
+		&params.Session,
+		"session",
+		"",
+		"Session to run the simulation in on the broker, or to attach to with -attach. Defaults to a new session, or when attaching, the only simulation running.")
+
+	flag.StringVar(
+		&params.Input,
+		"input",
+		"",
+		"Image to load the world from. Its size is read from the image, overriding -w and -h. Defaults to images/WxH.pgm.")
+
+	flag.StringVar(
+		&params.OutputDir,
+		"out",
+		"out",
+		"Directory to write images to.")
+
+	flag.StringVar(
+		&params.Format,
+		"format",
+		"pgm",
+		"Format to write images in: pgm, png, rle, cells or lif. Press i to save a png whatever the format.")
+
+	flag.StringVar(
+		&params.AliveColour,
+		"alive",
+		"#ffffff",
+		"Colour of alive cells in png images, as #rrggbb. Dying cells are shaded between this and -dead.")
+
+	flag.StringVar(
+		&params.DeadColour,
+		"dead",
+		"#000000",
+		"Colour of dead cells in png images, as #rrggbb.")
+
+	flag.IntVar(
+		&params.Scale,
+		"scale",
+		1,
+		"Number of pixels along each side of a cell in png images.")
+
+	flag.StringVar(
+		&params.Engine,
+		"engine",
+		"",
+		"Where to run the simulation: broker, or local to run it in this process without a broker. Defaults to $GOL_ENGINE, or else broker.")
+
+	offset := flag.String(
+		"offset",
+		"",
+		"Where to put the top left of a pattern given with -input, as x,y. Defaults to the middle of the board. Life 1.06 patterns have their 0,0 put here, so -offset 0,0 loads a saved .lif back where it was.")
+
 	headless := flag.Bool(
 		"headless",
 		false,
@@ -48,16 +138,89 @@ func main() {
 
 	flag.Parse()
 
+	if params.Input != "" && !params.Attach {
+		ruleSet := false
+		flag.Visit(func(f *flag.Flag) {
+			ruleSet = ruleSet || f.Name == "rule"
+		})
+		patternRule, err := gol.PatternRule(params.Input)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		if patternRule != "" && !ruleSet {
+			params.Rule = patternRule
+		}
+	}
+	rule, err := util.ParseRule(params.Rule)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	params.Rule = rule.String()
+	_, err = util.ParseTopology(params.Topology)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := gol.CheckEngine(params); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := gol.CheckOutput(params); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *offset != "" {
+		var x, y int
+		if _, err := fmt.Sscanf(*offset, "%d,%d", &x, &y); err != nil {
+			fmt.Fprintf(os.Stderr, "-offset %q: want the form x,y\n", *offset)
+			os.Exit(2)
+		}
+		params.Offset = &util.Cell{X: x, Y: y}
+	}
+	// Run would read the size from the image itself, but the window is opened at it first.
+	if params.Input != "" && !params.Attach && !gol.IsPattern(params.Input) {
+		params.ImageWidth, params.ImageHeight, err = gol.ReadImageSize(params.Input)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	if params.Attach {
+		sizeSet := false
+		flag.Visit(func(f *flag.Flag) {
+			sizeSet = sizeSet || f.Name == "w" || f.Name == "h"
+		})
+		if !sizeSet {
+			// The window is opened at the size of the world already running.
+			running, err := gol.SessionParams(params)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+			params.ImageWidth, params.ImageHeight = running.ImageWidth, running.ImageHeight
+			params.Session = running.Session
+			params.Turns = running.Turns
+			params.Rule = running.Rule
+			params.Topology = running.Topology
+		}
+	}
+
 	fmt.Printf("%-10v %v\n", "Threads", params.Threads)
 	fmt.Printf("%-10v %v\n", "Width", params.ImageWidth)
 	fmt.Printf("%-10v %v\n", "Height", params.ImageHeight)
 	fmt.Printf("%-10v %v\n", "Turns", params.Turns)
+	fmt.Printf("%-10v %v\n", "Rule", params.Rule)
+	fmt.Printf("%-10v %v\n", "Topology", params.Topology)
 
 	keyPresses := make(chan rune, 10)
 	events := make(chan gol.Event, 1000)
 
 	go sigterm(keyPresses)
 
+	// Only a window has anything to show the cells flipped each turn in.
+	params.Stream = !*headless
 	go gol.Run(params, events, keyPresses)
 	if !(*headless) {
 		sdl.Run(params, events, keyPresses)
@@ -22,8 +22,19 @@ func TestMain(m *testing.M) {
 		"sdl",
 		false,
 		"Enable the SDL window for testing.")
+	flag.String(
+		"broker",
+		"localhost:8030",
+		"IP:port string to connect to as broker. Without it the tests run in-process on the local engine, unless $GOL_ENGINE says otherwise.")
 
 	flag.Parse()
+	brokerSet := false
+	flag.Visit(func(f *flag.Flag) {
+		brokerSet = brokerSet || f.Name == "broker"
+	})
+	if !brokerSet && os.Getenv("GOL_ENGINE") == "" {
+		os.Setenv("GOL_ENGINE", "local")
+	}
 	done := make(chan int, 1)
 	test := func() { done <- m.Run() }
 	if !(*sdlFlag) {
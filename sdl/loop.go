@@ -34,10 +34,18 @@ sdl:
 						keyPresses <- 'p'
 					case sdl.K_s:
 						keyPresses <- 's'
+					case sdl.K_i:
+						keyPresses <- 'i'
 					case sdl.K_q:
 						keyPresses <- 'q'
 					case sdl.K_k:
 						keyPresses <- 'k'
+					case sdl.K_d:
+						keyPresses <- 'd'
+					case sdl.K_n:
+						keyPresses <- 'n'
+					case sdl.K_0, sdl.K_1, sdl.K_2, sdl.K_3, sdl.K_4, sdl.K_5, sdl.K_6, sdl.K_7, sdl.K_8, sdl.K_9:
+						keyPresses <- rune('0' + e.Keysym.Sym - sdl.K_0)
 					}
 				}
 			}
@@ -65,6 +73,10 @@ sdl:
 				fmt.Printf("Completed Turns %-8v %v\n", event.GetCompletedTurns(), event)
 			case gol.ImageOutputComplete:
 				fmt.Printf("Completed Turns %-8v %v\n", event.GetCompletedTurns(), event)
+			case gol.WorkerLost:
+				fmt.Printf("Completed Turns %-8v %v\n", event.GetCompletedTurns(), event)
+			case gol.CycleDetected:
+				fmt.Printf("Completed Turns %-8v %v\n", event.GetCompletedTurns(), event)
 			case gol.StateChange:
 				fmt.Printf("Completed Turns %-8v %v\n", event.GetCompletedTurns(), event)
 				if e.NewState == gol.Quitting {
@@ -85,6 +97,10 @@ func RunHeadless(events <-chan gol.Event) {
 			fmt.Printf("Completed Turns %-8v %v\n", event.GetCompletedTurns(), "Final Turn Complete")
 		case gol.ImageOutputComplete:
 			fmt.Printf("Completed Turns %-8v %v\n", event.GetCompletedTurns(), event)
+		case gol.WorkerLost:
+			fmt.Printf("Completed Turns %-8v %v\n", event.GetCompletedTurns(), event)
+		case gol.CycleDetected:
+			fmt.Printf("Completed Turns %-8v %v\n", event.GetCompletedTurns(), event)
 		case gol.StateChange:
 			fmt.Printf("Completed Turns %-8v %v\n", event.GetCompletedTurns(), event)
 			if e.NewState == gol.Quitting {
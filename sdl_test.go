@@ -19,6 +19,7 @@ func testSdlTurn(t *testing.T) {
 		Threads:     8,
 		ImageWidth:  512,
 		ImageHeight: 512,
+		Stream:      true,
 	}
 
 	keyPresses := make(chan rune, 10)
@@ -48,6 +49,7 @@ func testSdlImages(t *testing.T) {
 		Threads:     8,
 		ImageWidth:  512,
 		ImageHeight: 512,
+		Stream:      true,
 	}
 
 	keyPresses := make(chan rune, 10)
@@ -102,6 +104,7 @@ func testSdlAlive(t *testing.T) {
 		Threads:     8,
 		ImageWidth:  512,
 		ImageHeight: 512,
+		Stream:      true,
 	}
 
 	keyPresses := make(chan rune, 10)
@@ -1,82 +1,191 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"math/rand"
 	"net"
 	"net/rpc"
+	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 	"time"
+
+	"uk.ac.bris.cs/gameoflife/util"
 )
 
 type GOLOperations struct {
 }
 
+// residentStrip is the block of rows this server holds for one broker session.
+type residentStrip struct {
+	mutex    sync.Mutex
+	rows     [][]uint64
+	params   Params
+	startY   int
+	rule     util.Rule
+	topology util.Topology
+	changed  [][]uint64 // the words of each row that changed in the last turn
+	fetched  bool       // whether the broker already holds the strip as it is now
+}
+
 var (
 	terminateServerSignal = make(chan bool)
 	clientConnected       = false
 	wg                    sync.WaitGroup
-	numberOfServers       = 4
+	strips                = make(map[string]*residentStrip) // the strip held for each broker session
+	stripsMutex           sync.Mutex
+	threadsOverride       int
 )
 
+// threads is the number of goroutines to use, preferring the -threads flag over the client's Params.
+func (st *residentStrip) threads() int {
+	if threadsOverride > 0 {
+		return threadsOverride
+	}
+	return st.params.Threads
+}
+
+// stripFor returns the strip held for a session, locked, or an error if there is none.
+func stripFor(session string) (*residentStrip, error) {
+	stripsMutex.Lock()
+	st := strips[session]
+	stripsMutex.Unlock()
+	if st == nil {
+		return nil, errors.New("no strip loaded")
+	}
+	st.mutex.Lock()
+	return st, nil
+}
+
 func (s *GOLOperations) Terminate(req EmptyRequest, res *EmptyResponse) (err error) {
 	terminateServerSignal <- true
 	return
 }
 
-func (s *GOLOperations) CalculateNextState(req Request, res *ServerSliceResponse) (err error) {
-	p := req.P
-	world := req.World
-	serverNumber := req.ServerNumber
-
-	workerHeight := p.ImageHeight / numberOfServers
-	startHeight := serverNumber * workerHeight
-	endHeight := (serverNumber + 1) * workerHeight
-	if serverNumber == numberOfServers-1 {
-		endHeight += p.ImageHeight % numberOfServers
-	}
-	IMWD := p.ImageWidth
-
-	res.Slice = make([][]byte, endHeight-startHeight)
-	for i := range res.Slice {
-		res.Slice[i] = make([]byte, IMWD)
-	}
-
-	for y := startHeight; y < endHeight; y++ {
-		for x := 0; x < IMWD; x++ {
-			// Calculate sum of 8 neighbors
-			up := (y - 1 + p.ImageHeight) % p.ImageHeight
-			left := (x - 1 + p.ImageWidth) % p.ImageWidth
-			right := (x + 1) % p.ImageWidth
-			down := (y + 1) % p.ImageHeight
-
-			sum := int(world[up][left]) +
-				int(world[up][x]) +
-				int(world[up][right]) +
-				int(world[y][left]) +
-				int(world[y][right]) +
-				int(world[down][left]) +
-				int(world[down][x]) +
-				int(world[down][right])
-
-			if world[y][x] == 255 {
-				// Cell is alive
-				if sum < 2*255 || sum > 3*255 {
-					res.Slice[y-startHeight][x] = 0 // Underpopulation or overpopulation: cell dies
-				} else {
-					res.Slice[y-startHeight][x] = 255 // Cell survives
-				}
-			} else {
-				// Cell is dead
-				if sum == 3*255 {
-					res.Slice[y-startHeight][x] = 255 // Reproduction: cell becomes alive
-				} else {
-					res.Slice[y-startHeight][x] = 0 // Cell remains dead
-				}
+// LoadStrip stores the rows this server is responsible for in a session, replacing any it held
+// before. They stay resident between turns so that only halo rows need to be exchanged.
+func (s *GOLOperations) LoadStrip(req Request, res *EmptyResponse) (err error) {
+	rule, err := util.ParseRule(req.P.Rule)
+	if err != nil {
+		return err
+	}
+	topology, err := util.ParseTopology(req.P.Topology)
+	if err != nil {
+		return err
+	}
+	st := &residentStrip{
+		rows:     req.World.Rows,
+		params:   req.P,
+		startY:   req.StartY,
+		rule:     rule,
+		topology: topology,
+		changed:  util.AllWords(len(req.World.Rows), util.WordsPerRow(req.P.ImageWidth)),
+		fetched:  true,
+	}
+	stripsMutex.Lock()
+	defer stripsMutex.Unlock()
+	strips[req.Session] = st
+	return
+}
+
+// DropStrip forgets the strip held for a session, once the broker has no more use for it.
+func (s *GOLOperations) DropStrip(req SessionRequest, res *EmptyResponse) (err error) {
+	stripsMutex.Lock()
+	defer stripsMutex.Unlock()
+	delete(strips, req.Session)
+	return
+}
+
+// Step advances the session's resident strip by req.Turns turns. The halo holds that many rows from
+// each neighbouring strip, so one ghost row on each side becomes invalid every turn.
+// The first and last req.Depth rows of the new strip are returned for the neighbours,
+// along with the cells of the strip that flipped in each turn if req.Flips is set, and a hash
+// of the strip after each turn if req.Hashes is set.
+func (s *GOLOperations) Step(req HaloRequest, res *HaloResponse) (err error) {
+	st, err := stripFor(req.Session)
+	if err != nil {
+		return err
+	}
+	defer st.mutex.Unlock()
+	strip := st.rows
+	if len(req.Above) != req.Turns || len(req.Below) != req.Turns {
+		return fmt.Errorf("need %v halo rows on each side, got %v and %v", req.Turns, len(req.Above), len(req.Below))
+	}
+	if req.Depth > len(strip) {
+		return fmt.Errorf("cannot return %v edge rows from a strip of %v rows", req.Depth, len(strip))
+	}
+	rows := make([][]uint64, 0, len(strip)+2*req.Turns)
+	rows = append(rows, req.Above...)
+	rows = append(rows, strip...)
+	rows = append(rows, req.Below...)
+	// Nothing is known about how the halo rows have changed, so all of their words are active.
+	words := util.WordsPerRow(st.params.ImageWidth)
+	changed := make([][]uint64, 0, len(rows))
+	changed = append(changed, util.AllWords(req.Turns, words)...)
+	changed = append(changed, st.changed...)
+	changed = append(changed, util.AllWords(req.Turns, words)...)
+	unchanged := true
+	// Ghost rows beyond a top or bottom edge that is not joined to anything stay dead.
+	deadAbove := !st.topology.WrapsY() && st.startY == 0
+	deadBelow := !st.topology.WrapsY() && st.startY+len(strip) == st.params.ImageHeight
+	for turn := 0; turn < req.Turns; turn++ {
+		active := util.ActiveWords(changed, words, st.topology.WrapsX())
+		next := util.NextRows(st.params.ImageWidth, st.rule, st.topology, rows, active, st.threads())
+		ghosts := req.Turns - turn - 1
+		if deadAbove {
+			util.ClearRows(next[:ghosts])
+		}
+		if deadBelow {
+			util.ClearRows(next[len(next)-ghosts:])
+		}
+		changed = util.ChangedWords(rows, next, words)
+		if util.AnyWords(changed[ghosts : ghosts+len(strip)]) {
+			unchanged = false
+		}
+		if req.Hashes {
+			var hash uint64
+			for i, row := range next[ghosts : ghosts+len(strip)] {
+				hash += util.HashRow(st.startY+i, row)
 			}
+			res.Hashes = append(res.Hashes, hash)
+		}
+		if req.Flips {
+			// The strip starts one row further up in next, as it has lost a ghost row on each side.
+			offset := req.Turns - turn
+			res.Flips = append(res.Flips, util.FlippedCells(st.params.ImageWidth, st.startY, rows[offset:offset+len(strip)], next[offset-1:offset-1+len(strip)]))
 		}
+		rows = next
 	}
+	st.rows = rows
+	st.changed = changed
+	if unchanged {
+		// The broker's copies of the edge rows are still current.
+		res.Unchanged = true
+		return
+	}
+	st.fetched = false
+	res.Top = rows[:req.Depth]
+	res.Bottom = rows[len(rows)-req.Depth:]
+	return
+}
+
+// GetStrip returns the session's resident strip so the broker can assemble the whole world. If
+// the strip has not changed since the broker last loaded or fetched it, only that is reported.
+func (s *GOLOperations) GetStrip(req SessionRequest, res *ServerSliceResponse) (err error) {
+	st, err := stripFor(req.Session)
+	if err != nil {
+		return err
+	}
+	defer st.mutex.Unlock()
+	if st.fetched {
+		res.Unchanged = true
+		return
+	}
+	res.Slice = st.rows
+	st.fetched = true
 	return
 }
 
@@ -93,8 +202,31 @@ func handleClientConnection(conn net.Conn, server *rpc.Server) {
 	server.ServeConn(conn)
 }
 
+// register tells the broker that this server is ready to receive work.
+func register(brokerAddress, address string) error {
+	broker, err := rpc.Dial("tcp", brokerAddress)
+	if err != nil {
+		return err
+	}
+	defer broker.Close()
+	return broker.Call(RegisterWorkerHandler, ServerAddress{Address: address}, new(EmptyResponse))
+}
+
+// deregister removes this server from the broker's pool before shutting down.
+func deregister(brokerAddress, address string) error {
+	broker, err := rpc.Dial("tcp", brokerAddress)
+	if err != nil {
+		return err
+	}
+	defer broker.Close()
+	return broker.Call(DeregisterWorkerHandler, ServerAddress{Address: address}, new(EmptyResponse))
+}
+
 func main() {
 	pAddr := flag.String("port", "8050", "Port to listen on")
+	pIP := flag.String("ip", "localhost", "Address the broker should use to reach this server")
+	pBroker := flag.String("broker", "localhost:8040", "IP:port of the broker's server registration port (empty to wait to be dialled)")
+	flag.IntVar(&threadsOverride, "threads", 0, "Number of worker threads to use, overriding the client's -t (0 to use the client's value)")
 	flag.Parse()
 	rand.Seed(time.Now().UnixNano())
 
@@ -108,6 +240,9 @@ func main() {
 	}
 	defer listener.Close()
 
+	sigterm := make(chan os.Signal, 1)
+	signal.Notify(sigterm, syscall.SIGTERM, syscall.SIGINT)
+
 	// Channel to signal a new connection
 	connChan := make(chan net.Conn)
 	// Goroutine to handle accepting new connections
@@ -119,6 +254,15 @@ func main() {
 		}
 	}()
 
+	address := *pIP + ":" + *pAddr
+	if *pBroker != "" {
+		err = register(*pBroker, address)
+		if err != nil {
+			panic(fmt.Sprintf("Failed to register with broker: %v", err))
+		}
+		fmt.Println("Registered with broker")
+	}
+
 	for {
 		select {
 		case <-terminateServerSignal:
@@ -127,6 +271,15 @@ func main() {
 			wg.Wait()
 			fmt.Println("Terminate signal received. Shutting down server...")
 			return
+		case <-sigterm:
+			if *pBroker != "" {
+				err := deregister(*pBroker, address)
+				if err != nil {
+					fmt.Println("Failed to deregister from broker:", err)
+				}
+			}
+			fmt.Println("Shutting down server...")
+			return
 		case conn := <-connChan:
 			// Check if a client is already connected
 			if clientConnected {
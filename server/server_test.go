@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// stepWhole advances a whole world by one turn with NextRows, as a reference for Step.
+func stepWhole(board util.BitBoard, rule util.Rule, topology util.Topology) util.BitBoard {
+	width, height := board.Width, board.Height
+	rows := append(append(append([][]uint64{}, topology.EdgeRows(width, board.Rows[height-1:])...), board.Rows...), topology.EdgeRows(width, board.Rows[:1])...)
+	next := util.NextRows(width, rule, topology, rows, util.AllWords(height, util.WordsPerRow(width)), 1)
+	return util.BitBoard{Width: width, Height: height, AgePlanes: board.AgePlanes, Rows: next}
+}
+
+// copyRows returns a copy of rows that Step cannot change the originals through.
+func copyRows(rows [][]uint64) [][]uint64 {
+	copied := make([][]uint64, len(rows))
+	for y, row := range rows {
+		copied[y] = append([]uint64{}, row...)
+	}
+	return copied
+}
+
+// TestStep checks that stepping strips for several turns at once, with that many halo rows from
+// each neighbour passed as the broker passes them, matches stepping the whole world a turn at a
+// time, for every topology. Under the plane and the cylinder the ghost rows past the top and
+// bottom edges must stay dead throughout the batch.
+func TestStep(t *testing.T) {
+	width, height := 70, 16
+	stripHeights := []int{6, 5, 5}
+	for _, ruleName := range []string{"B3/S23", "B36/S23", "345/2/4"} {
+		rule, err := util.ParseRule(ruleName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for topology := util.Torus; topology <= util.Klein; topology++ {
+			for _, turns := range []int{1, 2, 3, 5} {
+				name := fmt.Sprintf("%v-%v-%v", rule, topology, turns)
+				t.Run(name, func(t *testing.T) {
+					rng := rand.New(rand.NewSource(int64(turns)))
+					board := util.NewStateBoard(width, height, rule.States)
+					for y := 0; y < height; y++ {
+						for x := 0; x < width; x++ {
+							if rng.Intn(3) == 0 {
+								board.SetState(x, y, 1+rng.Intn(rule.States-1))
+							}
+						}
+					}
+					p := Params{Threads: 2, ImageWidth: width, ImageHeight: height, Rule: rule.String(), Topology: topology.String()}
+					ops := new(GOLOperations)
+
+					for batch := 0; batch < 3; batch++ {
+						want := board
+						for turn := 0; turn < turns; turn++ {
+							want = stepWhole(want, rule, topology)
+						}
+
+						startY := 0
+						for i, stripHeight := range stripHeights {
+							session := fmt.Sprintf("%v-%v", name, i)
+							endY := startY + stripHeight
+							err := ops.LoadStrip(Request{P: p, World: util.BitBoard{Rows: copyRows(board.Rows[startY:endY])}, StartY: startY, Session: session}, new(EmptyResponse))
+							if err != nil {
+								t.Fatal(err)
+							}
+							above := board.Rows[(startY-turns+height)%height:][:turns]
+							if startY == 0 {
+								above = topology.EdgeRows(width, board.Rows[height-turns:])
+							}
+							below := board.Rows[endY%height:][:turns]
+							if endY == height {
+								below = topology.EdgeRows(width, board.Rows[:turns])
+							}
+							res := new(HaloResponse)
+							err = ops.Step(HaloRequest{Session: session, Above: copyRows(above), Below: copyRows(below), Turns: turns, Depth: turns}, res)
+							if err != nil {
+								t.Fatal(err)
+							}
+							strip := new(ServerSliceResponse)
+							if err := ops.GetStrip(SessionRequest{Session: session}, strip); err != nil {
+								t.Fatal(err)
+							}
+							if !strip.Unchanged {
+								got := util.BitBoard{Width: width, Height: stripHeight, AgePlanes: board.AgePlanes, Rows: strip.Slice}
+								for y := 0; y < stripHeight; y++ {
+									for x := 0; x < width; x++ {
+										if got.State(x, y) != want.State(x, startY+y) {
+											t.Fatalf("batch %v: cell (%v, %v) has state %v, want %v", batch, x, startY+y, got.State(x, y), want.State(x, startY+y))
+										}
+									}
+								}
+								if fmt.Sprint(res.Top) != fmt.Sprint(strip.Slice[:turns]) || fmt.Sprint(res.Bottom) != fmt.Sprint(strip.Slice[stripHeight-turns:]) {
+									t.Fatalf("batch %v: the edge rows returned are not those of the strip", batch)
+								}
+							}
+							ops.DropStrip(SessionRequest{Session: session}, new(EmptyResponse))
+							startY = endY
+						}
+						board = want
+					}
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package util
+
+// Activity is tracked for each 64-cell word of each row: a word is only recalculated if it or
+// one of the eight words around it changed in the previous turn. Everywhere else the world is
+// still, so the word is copied across. Sets of words are held as bitsets, one bit per word.
+
+// newWordSets returns an empty bitset for each of n rows, sharing one allocation.
+func newWordSets(n, words int) [][]uint64 {
+	size := (words + 63) / 64
+	backing := make([]uint64, n*size)
+	sets := make([][]uint64, n)
+	for i := range sets {
+		sets[i] = backing[i*size : (i+1)*size : (i+1)*size]
+	}
+	return sets
+}
+
+// AllWords returns a bitset for each of n rows with every one of the given number of words set.
+func AllWords(n, words int) [][]uint64 {
+	sets := newWordSets(n, words)
+	for i := range sets {
+		for w := 0; w < words; w++ {
+			sets[i][w/64] |= 1 << uint(w%64)
+		}
+	}
+	return sets
+}
+
+// ActiveWords returns, for every row of changed except the first and last, the words that
+// need recalculating because they or a neighbouring word changed.
+func ActiveWords(changed [][]uint64, words int, wrap bool) [][]uint64 {
+	active := newWordSets(len(changed)-2, words)
+	set := make([]uint64, len(changed[0]))
+	for i := range active {
+		for j := range set {
+			set[j] = changed[i][j] | changed[i+1][j] | changed[i+2][j]
+		}
+		spreadWords(set, active[i], words, wrap)
+	}
+	return active
+}
+
+// spreadWords writes set into spread with the words either side of every word in set added.
+func spreadWords(set, spread []uint64, words int, wrap bool) {
+	last := len(set) - 1
+	for j, bits := range set {
+		spread[j] = bits | bits<<1 | bits>>1
+		if j > 0 {
+			spread[j] |= set[j-1] >> 63
+		}
+		if j < last {
+			spread[j] |= set[j+1] << 63
+		}
+	}
+	lastBit := uint((words - 1) % 64)
+	if wrap {
+		if set[0]&1 != 0 {
+			spread[last] |= 1 << lastBit
+		}
+		if set[last]&(1<<lastBit) != 0 {
+			spread[0] |= 1
+		}
+	}
+	spread[last] &= 1<<(lastBit+1) - 1
+}
+
+// isActive reports whether word w is in set.
+func isActive(set []uint64, w int) bool {
+	return set[w/64]&(1<<uint(w%64)) != 0
+}
+
+// ChangedWords compares each row of next with the row of rows it came from, one further down,
+// and returns the words that differ in any plane.
+func ChangedWords(rows, next [][]uint64, words int) [][]uint64 {
+	changed := newWordSets(len(next), words)
+	for i, row := range next {
+		set := changed[i]
+		before := rows[i+1]
+		for j := range row {
+			if row[j] != before[j] {
+				w := j % words
+				set[w/64] |= 1 << uint(w%64)
+			}
+		}
+	}
+	return changed
+}
+
+// AnyWords reports whether any of the sets has a word in it.
+func AnyWords(sets [][]uint64) bool {
+	for _, set := range sets {
+		for _, bits := range set {
+			if bits != 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package util
+
+import "math/bits"
+
+// BitBoard is a world packed one bit per cell. Each row is a slice of 64-bit words
+// with cell x stored in bit x%64 of word x/64. Bits past the width are always zero.
+//
+// Under a Generations rule each row is followed by AgePlanes more runs of words of the
+// same length. Together they hold, in binary, how many turns a dying cell has been dying,
+// so that a cell's state is 0 when dead, 1 when alive and 1 + its age while dying.
+type BitBoard struct {
+	Width     int
+	Height    int
+	AgePlanes int
+	Rows      [][]uint64
+}
+
+// WordsPerRow is the number of 64-bit words needed to hold a row of the given width.
+func WordsPerRow(width int) int {
+	return (width + 63) / 64
+}
+
+// NewBitBoard returns an empty board of the given size.
+func NewBitBoard(width, height int) BitBoard {
+	rows := make([][]uint64, height)
+	for i := range rows {
+		rows[i] = make([]uint64, WordsPerRow(width))
+	}
+	return BitBoard{Width: width, Height: height, Rows: rows}
+}
+
+// NewStateBoard returns an empty board with room for cells with the given number of states.
+func NewStateBoard(width, height, states int) BitBoard {
+	board := BitBoard{Width: width, Height: height, AgePlanes: AgePlanes(states)}
+	board.Rows = make([][]uint64, height)
+	for i := range board.Rows {
+		board.Rows[i] = make([]uint64, WordsPerRow(width)*(1+board.AgePlanes))
+	}
+	return board
+}
+
+// AgePlanes is the number of extra words per row needed for the ages of dying cells.
+func AgePlanes(states int) int {
+	if states <= 2 {
+		return 0
+	}
+	return bits.Len(uint(states - 2))
+}
+
+// Alive reports whether the cell at x, y is alive.
+func (board BitBoard) Alive(x, y int) bool {
+	return board.Rows[y][x/64]&(1<<uint(x%64)) != 0
+}
+
+// Set makes the cell at x, y alive or dead.
+func (board BitBoard) Set(x, y int, alive bool) {
+	if alive {
+		board.SetState(x, y, 1)
+	} else {
+		board.SetState(x, y, 0)
+	}
+}
+
+// State returns the state of the cell at x, y: 0 for dead, 1 for alive and higher while dying.
+func (board BitBoard) State(x, y int) int {
+	if board.Alive(x, y) {
+		return 1
+	}
+	words := WordsPerRow(board.Width)
+	age := 0
+	for plane := 0; plane < board.AgePlanes; plane++ {
+		if board.Rows[y][(plane+1)*words+x/64]&(1<<uint(x%64)) != 0 {
+			age |= 1 << uint(plane)
+		}
+	}
+	if age == 0 {
+		return 0
+	}
+	return age + 1
+}
+
+// SetState changes the state of the cell at x, y.
+func (board BitBoard) SetState(x, y, state int) {
+	words := WordsPerRow(board.Width)
+	age := state - 1
+	if state <= 1 {
+		age = 0
+	}
+	for plane := 0; plane <= board.AgePlanes; plane++ {
+		set := state == 1
+		if plane > 0 {
+			set = age&(1<<uint(plane-1)) != 0
+		}
+		if set {
+			board.Rows[y][plane*words+x/64] |= 1 << uint(x%64)
+		} else {
+			board.Rows[y][plane*words+x/64] &^= 1 << uint(x%64)
+		}
+	}
+}
+
+// AliveCells lists every alive cell on the board. Dying cells are not included.
+func (board BitBoard) AliveCells() []Cell {
+	var aliveCells []Cell
+	words := WordsPerRow(board.Width)
+	for y, row := range board.Rows {
+		for w, word := range row[:words] {
+			for word != 0 {
+				bit := bits.TrailingZeros64(word)
+				aliveCells = append(aliveCells, Cell{X: w*64 + bit, Y: y})
+				word &= word - 1
+			}
+		}
+	}
+	return aliveCells
+}
+
+// Hash returns a hash of the board. It is the sum of HashRow over the rows, so the hash of a
+// board split into strips is the sum of the hashes of the strips.
+func (board BitBoard) Hash() uint64 {
+	var hash uint64
+	for y, row := range board.Rows {
+		hash += HashRow(y, row)
+	}
+	return hash
+}
+
+// HashRow returns a hash of row y of a board, including any age planes.
+func HashRow(y int, row []uint64) uint64 {
+	hash := mix(uint64(y) + 1)
+	for _, word := range row {
+		hash = mix(hash ^ word)
+	}
+	return hash
+}
+
+// mix scrambles the bits of x, as in the finaliser of SplitMix64.
+func mix(x uint64) uint64 {
+	x = (x ^ x>>30) * 0xbf58476d1ce4e5b9
+	x = (x ^ x>>27) * 0x94d049bb133111eb
+	return x ^ x>>31
+}
@@ -0,0 +1,205 @@
+package util
+
+import (
+	"math/bits"
+	"sync"
+)
+
+// The kernel advances rows of a BitBoard by a turn. It is shared by the servers, which each
+// hold a strip of the world, and by the local engine, which holds all of it.
+
+// NextRows returns the next state of every row in rows except the first and last, which are
+// the rows above and below that only take part as neighbours. Rows are width cells wide, as
+// in a BitBoard. Only the words in active, which has a bitset for each row of the result, are
+// recalculated; the rest are copied. The rows are split between the given number of goroutines.
+func NextRows(width int, rule Rule, topology Topology, rows, active [][]uint64, threads int) [][]uint64 {
+	next := make([][]uint64, len(rows)-2)
+	for i := range next {
+		next[i] = make([]uint64, len(rows[0]))
+	}
+
+	if threads > len(next) {
+		threads = len(next)
+	}
+	if threads < 1 {
+		threads = 1
+	}
+	var threadsWg sync.WaitGroup
+	for i := 0; i < threads; i++ {
+		threadsWg.Add(1)
+		go func(startY, endY int) {
+			defer threadsWg.Done()
+			calculateRows(width, rule, topology, rows, next, active, startY, endY)
+		}(1+i*len(next)/threads, 1+(i+1)*len(next)/threads)
+	}
+	threadsWg.Wait()
+	return next
+}
+
+// calculateRows writes the next state of rows startY to endY into next, which is offset by one row.
+// Cells are processed 64 at a time: the eight neighbours of every cell in a word are summed into
+// a 4-bit counter held across four words, one bit of the count in each.
+// Only the first words of each row, the alive cells, take part in the count; the age planes
+// after them are advanced by ageCells.
+func calculateRows(width int, rule Rule, topology Topology, rows, next, active [][]uint64, startY, endY int) {
+	words := WordsPerRow(width)
+	// Rules where cells are born with no neighbours would otherwise bring the padding to life.
+	tail := ^uint64(0) >> uint(64*words-width)
+	west := [3][]uint64{make([]uint64, words), make([]uint64, words), make([]uint64, words)}
+	east := [3][]uint64{make([]uint64, words), make([]uint64, words), make([]uint64, words)}
+	for i := 0; i < 3; i++ {
+		shiftRow(width, topology.WrapsX(), rows[startY-1+i][:words], west[i], east[i])
+	}
+	agePlanes := AgePlanes(rule.States)
+
+	for y := startY; y < endY; y++ {
+		up, row, down := rows[y-1], rows[y], rows[y+1]
+		for w := 0; w < words; w++ {
+			if !isActive(active[y-1], w) {
+				next[y-1][w] = row[w]
+				continue
+			}
+			var c0, c1, c2, c3 uint64
+			for _, neighbours := range [8]uint64{
+				west[0][w], up[w], east[0][w],
+				west[1][w], east[1][w],
+				west[2][w], down[w], east[2][w],
+			} {
+				carry := neighbours
+				c0, carry = c0^carry, c0&carry
+				c1, carry = c1^carry, c1&carry
+				c2, carry = c2^carry, c2&carry
+				c3 |= carry
+			}
+			if rule == Conway {
+				// Born with exactly 3 neighbours, survives with 2 or 3.
+				twoOrThree := c1 &^ c2 &^ c3
+				next[y-1][w] = twoOrThree & (c0 | row[w])
+			} else {
+				next[y-1][w] = applyRule(rule, row[w], c0, c1, c2, c3)
+			}
+		}
+		next[y-1][words-1] &= tail
+		if agePlanes > 0 {
+			ageCells(rule, words, agePlanes, row, next[y-1])
+		}
+
+		if y+1 < endY {
+			// Slide the window of shifted rows down by one.
+			west[0], west[1], west[2] = west[1], west[2], west[0]
+			east[0], east[1], east[2] = east[1], east[2], east[0]
+			shiftRow(width, topology.WrapsX(), rows[y+2][:words], west[2], east[2])
+		}
+	}
+}
+
+// applyRule returns the next state of a word of cells given the four bits of each cell's
+// neighbour count.
+func applyRule(rule Rule, cells, c0, c1, c2, c3 uint64) uint64 {
+	var born, survive uint64
+	for n := uint(0); n <= 8; n++ {
+		if (rule.Birth|rule.Survive)&(1<<n) == 0 {
+			continue
+		}
+		count := ^uint64(0)
+		for i, c := range [4]uint64{c0, c1, c2, c3} {
+			if n&(1<<uint(i)) != 0 {
+				count &= c
+			} else {
+				count &^= c
+			}
+		}
+		if rule.Birth&(1<<n) != 0 {
+			born |= count
+		}
+		if rule.Survive&(1<<n) != 0 {
+			survive |= count
+		}
+	}
+	return born&^cells | survive&cells
+}
+
+// ageCells advances the dying cells of a row under a Generations rule. next already holds the
+// cells that applyRule found alive, which must not include dying cells. Alive cells that did not
+// survive start dying, dying cells get one turn older, and those at the last state die.
+func ageCells(rule Rule, words, agePlanes int, row, next []uint64) {
+	lastAge := uint(rule.States - 2)
+	for w := 0; w < words; w++ {
+		var dying uint64
+		for plane := 1; plane <= agePlanes; plane++ {
+			dying |= row[plane*words+w]
+		}
+		next[w] &^= dying
+
+		expiring := dying
+		carry := dying
+		for plane := 1; plane <= agePlanes; plane++ {
+			age := row[plane*words+w]
+			if lastAge&(1<<uint(plane-1)) != 0 {
+				expiring &= age
+			} else {
+				expiring &^= age
+			}
+			next[plane*words+w] = age ^ carry
+			carry &= age
+		}
+		for plane := 1; plane <= agePlanes; plane++ {
+			next[plane*words+w] &^= expiring
+		}
+		next[words+w] |= row[w] &^ next[w]
+	}
+}
+
+// shiftRow fills west and east with the row moved one cell to the right and left respectively,
+// so that each cell lines up with its west or east neighbour. If wrap is set the row wraps around
+// at the edges, otherwise the cells beyond them are dead.
+func shiftRow(width int, wrap bool, row, west, east []uint64) {
+	last := len(row) - 1
+	lastBit := uint((width - 1) % 64)
+	for w := range row {
+		if w == 0 {
+			west[w] = row[w] << 1
+			if wrap {
+				west[w] |= (row[last] >> lastBit) & 1
+			}
+		} else {
+			west[w] = row[w]<<1 | row[w-1]>>63
+		}
+		if w == last {
+			east[w] = row[w] >> 1
+			if wrap {
+				east[w] |= (row[0] & 1) << lastBit
+			}
+		} else {
+			east[w] = row[w]>>1 | row[w+1]<<63
+		}
+	}
+	west[last] &= 1<<(lastBit+1) - 1
+}
+
+// ClearRows makes every cell in rows dead.
+func ClearRows(rows [][]uint64) {
+	for _, row := range rows {
+		for w := range row {
+			row[w] = 0
+		}
+	}
+}
+
+// FlippedCells lists the cells that came alive or stopped being alive between two versions of
+// the rows of a board of the given width, the first of which is row startY.
+func FlippedCells(width, startY int, before, after [][]uint64) []Cell {
+	var cells []Cell
+	words := WordsPerRow(width)
+	for y := range before {
+		for w := range before[y][:words] {
+			changed := before[y][w] ^ after[y][w]
+			for changed != 0 {
+				bit := bits.TrailingZeros64(changed)
+				cells = append(cells, Cell{X: w*64 + bit, Y: startY + y})
+				changed &= changed - 1
+			}
+		}
+	}
+	return cells
+}
@@ -0,0 +1,248 @@
+package util
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// kernelRules are the rules the kernel is tested with: Conway's, one where cells are born with
+// no neighbours, so the padding past the width must stay dead, and two Generations rules.
+var kernelRules = []string{"B3/S23", "B36/S23", "B013/S0", "/2/3", "345/2/4"}
+
+// kernelSizes are the world sizes the kernel is tested with, including widths that are not a
+// whole number of words and worlds only one or two rows high.
+var kernelSizes = [][2]int{{1, 1}, {5, 1}, {7, 2}, {16, 16}, {64, 3}, {70, 9}, {130, 12}}
+
+// randomBoard returns a board with about a third of its cells alive and, under a Generations
+// rule, as many in each dying state.
+func randomBoard(rng *rand.Rand, width, height, states int) BitBoard {
+	board := NewStateBoard(width, height, states)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if rng.Intn(3) == 0 {
+				board.SetState(x, y, 1+rng.Intn(states-1))
+			}
+		}
+	}
+	return board
+}
+
+// naiveStep advances a board by one turn a cell at a time, as a reference for the kernel.
+func naiveStep(board BitBoard, rule Rule, topology Topology) BitBoard {
+	width, height := board.Width, board.Height
+	alive := func(x, y int) bool {
+		if y < 0 || y >= height {
+			if !topology.WrapsY() {
+				return false
+			}
+			y = (y + height) % height
+			if topology.MirrorsY() {
+				x = width - 1 - x
+			}
+		}
+		if x < 0 || x >= width {
+			if !topology.WrapsX() {
+				return false
+			}
+			x = (x + width) % width
+		}
+		return board.State(x, y) == 1
+	}
+
+	next := NewStateBoard(width, height, rule.States)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			neighbours := 0
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					if (dx != 0 || dy != 0) && alive(x+dx, y+dy) {
+						neighbours++
+					}
+				}
+			}
+			state := board.State(x, y)
+			switch {
+			case state == 0 && rule.Next(false, neighbours):
+				next.SetState(x, y, 1)
+			case state == 1 && rule.Next(true, neighbours):
+				next.SetState(x, y, 1)
+			case state == 1 && rule.States > 2:
+				next.SetState(x, y, 2)
+			case state >= 2 && state+1 < rule.States:
+				next.SetState(x, y, state+1)
+			}
+		}
+	}
+	return next
+}
+
+// stepStrips advances a board by one turn with NextRows, split into strips of the given height
+// as the servers hold it, each given the rows either side of it as the broker would.
+func stepStrips(board BitBoard, rule Rule, topology Topology, stripHeight, threads int) BitBoard {
+	width, height := board.Width, board.Height
+	words := WordsPerRow(width)
+	next := BitBoard{Width: width, Height: height, AgePlanes: board.AgePlanes}
+	for startY := 0; startY < height; startY += stripHeight {
+		endY := startY + stripHeight
+		if endY > height {
+			endY = height
+		}
+		var above, below [][]uint64
+		if startY == 0 {
+			above = topology.EdgeRows(width, board.Rows[height-1:])
+		} else {
+			above = board.Rows[startY-1 : startY]
+		}
+		if endY == height {
+			below = topology.EdgeRows(width, board.Rows[:1])
+		} else {
+			below = board.Rows[endY : endY+1]
+		}
+		rows := append(append(append([][]uint64{}, above...), board.Rows[startY:endY]...), below...)
+		next.Rows = append(next.Rows, NextRows(width, rule, topology, rows, AllWords(endY-startY, words), threads)...)
+	}
+	return next
+}
+
+// boardsDiffer describes the first cell in which two boards differ, or returns "" if they agree.
+func boardsDiffer(got, want BitBoard) string {
+	for y := 0; y < want.Height; y++ {
+		for x := 0; x < want.Width; x++ {
+			if got.State(x, y) != want.State(x, y) {
+				return fmt.Sprintf("cell (%v, %v) has state %v, want %v", x, y, got.State(x, y), want.State(x, y))
+			}
+		}
+	}
+	for y, row := range got.Rows {
+		if len(row) != len(want.Rows[y]) {
+			return fmt.Sprintf("row %v is %v words, want %v", y, len(row), len(want.Rows[y]))
+		}
+		for w := range row {
+			if row[w] != want.Rows[y][w] {
+				return fmt.Sprintf("row %v word %v is %x, want %x: the padding past the width is not dead", y, w, row[w], want.Rows[y][w])
+			}
+		}
+	}
+	return ""
+}
+
+// TestNextRows checks NextRows against naiveStep for every topology and rule, with the world
+// held whole and split into strips as small as one row.
+func TestNextRows(t *testing.T) {
+	for _, ruleName := range kernelRules {
+		rule, err := ParseRule(ruleName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for topology := Torus; topology <= Klein; topology++ {
+			for _, size := range kernelSizes {
+				width, height := size[0], size[1]
+				t.Run(fmt.Sprintf("%v-%v-%dx%d", rule, topology, width, height), func(t *testing.T) {
+					rng := rand.New(rand.NewSource(int64(width*1000 + height)))
+					board := randomBoard(rng, width, height, rule.States)
+					for turn := 1; turn <= 4; turn++ {
+						want := naiveStep(board, rule, topology)
+						for _, stripHeight := range []int{height, 1, 2, 5} {
+							got := stepStrips(board, rule, topology, stripHeight, 1+turn%3)
+							if diff := boardsDiffer(got, want); diff != "" {
+								t.Fatalf("turn %v, strips of %v rows: %v", turn, stripHeight, diff)
+							}
+						}
+						board = want
+					}
+				})
+			}
+		}
+	}
+}
+
+// stepActive advances a board by one turn with NextRows, only recalculating the words that
+// ActiveWords picks out from the words that changed in the last turn, as the local engine does.
+// It returns the words that changed in this turn along with the board.
+func stepActive(board BitBoard, changed [][]uint64, rule Rule, topology Topology) (BitBoard, [][]uint64) {
+	width, height := board.Width, board.Height
+	words := WordsPerRow(width)
+	rows := append(append(append([][]uint64{}, topology.EdgeRows(width, board.Rows[height-1:])...), board.Rows...), topology.EdgeRows(width, board.Rows[:1])...)
+	// The rows across the top and bottom edges are treated as changed, as they may be mirrored.
+	changed = append(append(append([][]uint64{}, AllWords(1, words)...), changed...), AllWords(1, words)...)
+	next := NextRows(width, rule, topology, rows, ActiveWords(changed, words, topology.WrapsX()), 2)
+	return BitBoard{Width: width, Height: height, AgePlanes: board.AgePlanes, Rows: next}, ChangedWords(rows, next, words)
+}
+
+// TestActiveWords checks which words are active around a changed word, including across the
+// left and right edges and past the 64 words that fit in one word of a bitset.
+func TestActiveWords(t *testing.T) {
+	words := 70
+	tests := []struct {
+		changedWord int
+		wrap        bool
+		want        []int
+	}{
+		{changedWord: 10, want: []int{9, 10, 11}},
+		{changedWord: 63, want: []int{62, 63, 64}},
+		{changedWord: 64, want: []int{63, 64, 65}},
+		{changedWord: 0, want: []int{0, 1}},
+		{changedWord: 0, wrap: true, want: []int{0, 1, 69}},
+		{changedWord: 69, want: []int{68, 69}},
+		{changedWord: 69, wrap: true, want: []int{0, 68, 69}},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%v-%v", test.changedWord, test.wrap), func(t *testing.T) {
+			// The change is in the middle of three rows, so every row of the result sees it.
+			changed := newWordSets(5, words)
+			changed[2][test.changedWord/64] |= 1 << uint(test.changedWord%64)
+			active := ActiveWords(changed, words, test.wrap)
+			for y, set := range active {
+				var got []int
+				for w := 0; w < words; w++ {
+					if isActive(set, w) {
+						got = append(got, w)
+					}
+				}
+				if fmt.Sprint(got) != fmt.Sprint(test.want) {
+					t.Errorf("row %v: active words %v, want %v", y, got, test.want)
+				}
+			}
+		})
+	}
+}
+
+// TestActiveNextRows runs worlds for several turns recalculating only the active words, and
+// checks every turn against naiveStep.
+func TestActiveNextRows(t *testing.T) {
+	sizes := append(kernelSizes, [2]int{200, 8}, [2]int{4200, 3})
+	for _, ruleName := range kernelRules {
+		rule, err := ParseRule(ruleName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for topology := Torus; topology <= Klein; topology++ {
+			for _, size := range sizes {
+				width, height := size[0], size[1]
+				t.Run(fmt.Sprintf("%v-%v-%dx%d", rule, topology, width, height), func(t *testing.T) {
+					rng := rand.New(rand.NewSource(int64(width*1000 + height)))
+					board := NewStateBoard(width, height, rule.States)
+					// A small patch leaves most of a wide world still, so most words are skipped.
+					// It straddles the left and right edges, so changes must spread across them.
+					patch := randomBoard(rng, 8, height, rule.States)
+					for y := 0; y < height; y++ {
+						for x := 0; x < 8 && x < width; x++ {
+							board.SetState((width-1+x)%width, y, patch.State(x, y))
+						}
+					}
+					changed := AllWords(height, WordsPerRow(width))
+					for turn := 1; turn <= 12; turn++ {
+						want := naiveStep(board, rule, topology)
+						var got BitBoard
+						got, changed = stepActive(board, changed, rule, topology)
+						if diff := boardsDiffer(got, want); diff != "" {
+							t.Fatalf("turn %v: %v", turn, diff)
+						}
+						board = want
+					}
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Rule is a life-like or Generations rule. Bit n of Birth is set if a dead cell with n alive
+// neighbours comes alive, and bit n of Survive is set if an alive cell with n alive neighbours
+// stays alive. Cells have States states: with more than two, an alive cell that does not
+// survive spends States-2 turns dying, during which it is neither alive nor able to be born.
+type Rule struct {
+	Birth   uint16
+	Survive uint16
+	States  int
+}
+
+// Conway is the rule of Conway's Game of Life, B3/S23.
+var Conway = Rule{Birth: 1 << 3, Survive: 1<<2 | 1<<3, States: 2}
+
+// namedRules are the well-known rules that may be given by name instead of in B/S notation.
+var namedRules = map[string]string{
+	"life":             "B3/S23",
+	"conway":           "B3/S23",
+	"highlife":         "B36/S23",
+	"seeds":            "B2/S",
+	"daynight":         "B3678/S34678",
+	"replicator":       "B1357/S1357",
+	"maze":             "B3/S12345",
+	"2x2":              "B36/S125",
+	"lifewithoutdeath": "B3/S012345678",
+	"briansbrain":      "/2/3",
+	"starwars":         "345/2/4",
+}
+
+// ParseRule reads a rule in B/S notation such as "B36/S23", the older S/B notation such as
+// "23/36", or one of a few well-known names such as "highlife". A third part gives the number
+// of states of a Generations rule, as in "B2/S/C3" or "/2/3" for Brian's Brain.
+// An empty string is Conway's rule.
+func ParseRule(s string) (Rule, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Conway, nil
+	}
+	if named, ok := namedRules[strings.ToLower(s)]; ok {
+		s = named
+	}
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 && len(parts) != 3 {
+		return Rule{}, fmt.Errorf("rule %q: want the form B3/S23 or B2/S/C3", s)
+	}
+
+	rule := Rule{States: 2}
+	var err error
+	if len(parts) == 3 {
+		states := strings.TrimLeft(strings.ToUpper(parts[2]), "CG")
+		rule.States, err = strconv.Atoi(states)
+		if err != nil || rule.States < 2 {
+			return Rule{}, fmt.Errorf("rule %q: %q is not a number of states of at least 2", s, parts[2])
+		}
+	}
+	first, second := strings.ToUpper(parts[0]), strings.ToUpper(parts[1])
+	switch {
+	case strings.HasPrefix(first, "B") && strings.HasPrefix(second, "S"):
+		rule.Birth, err = parseCounts(first[1:])
+		if err == nil {
+			rule.Survive, err = parseCounts(second[1:])
+		}
+	case strings.HasPrefix(first, "S") && strings.HasPrefix(second, "B"):
+		rule.Survive, err = parseCounts(first[1:])
+		if err == nil {
+			rule.Birth, err = parseCounts(second[1:])
+		}
+	default:
+		rule.Survive, err = parseCounts(first)
+		if err == nil {
+			rule.Birth, err = parseCounts(second)
+		}
+	}
+	if err != nil {
+		return Rule{}, fmt.Errorf("rule %q: %v", s, err)
+	}
+	return rule, nil
+}
+
+// parseCounts turns a list of neighbour counts such as "236" into a bit mask.
+func parseCounts(s string) (uint16, error) {
+	var mask uint16
+	for _, r := range s {
+		if r < '0' || r > '8' {
+			return 0, fmt.Errorf("%q is not a neighbour count from 0 to 8", r)
+		}
+		mask |= 1 << uint(r-'0')
+	}
+	return mask, nil
+}
+
+// Next reports whether a cell with the given state and number of alive neighbours is alive next turn.
+func (rule Rule) Next(alive bool, neighbours int) bool {
+	if alive {
+		return rule.Survive&(1<<uint(neighbours)) != 0
+	}
+	return rule.Birth&(1<<uint(neighbours)) != 0
+}
+
+// String returns the rule in B/S notation, or B/S/C notation for a Generations rule.
+func (rule Rule) String() string {
+	s := "B" + countsString(rule.Birth) + "/S" + countsString(rule.Survive)
+	if rule.States > 2 {
+		s += "/C" + strconv.Itoa(rule.States)
+	}
+	return s
+}
+
+// Grey returns the grey level used for a state in an image: white for alive, black for dead
+// and evenly spaced greys, getting darker, for the states of a dying cell.
+func (rule Rule) Grey(state int) uint8 {
+	switch {
+	case state == 0:
+		return 0
+	case state == 1:
+		return 255
+	default:
+		return uint8(255 - 255*(state-1)/(rule.States-1))
+	}
+}
+
+// StateOfGrey returns the state whose grey level is nearest to the given one, so that with two
+// states any grey at least half way to white is alive.
+func (rule Rule) StateOfGrey(grey uint8) int {
+	if grey == 0 {
+		return 0
+	}
+	if rule.States <= 2 {
+		if grey < 128 {
+			return 0
+		}
+		return 1
+	}
+	best, bestDistance := 0, 256
+	for state := 0; state < rule.States; state++ {
+		distance := int(rule.Grey(state)) - int(grey)
+		if distance < 0 {
+			distance = -distance
+		}
+		if distance < bestDistance {
+			best, bestDistance = state, distance
+		}
+	}
+	return best
+}
+
+func countsString(mask uint16) string {
+	var b strings.Builder
+	for n := 0; n <= 8; n++ {
+		if mask&(1<<uint(n)) != 0 {
+			b.WriteByte(byte('0' + n))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,66 @@
+package util
+
+import "testing"
+
+// TestParseRule checks B/S, S/B and B/S/C notation, the named rules and rejected rules.
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    Rule
+		wantErr bool
+	}{
+		{s: "", want: Conway},
+		{s: "B3/S23", want: Conway},
+		{s: "b3/s23", want: Conway},
+		{s: " B3/S23 ", want: Conway},
+		{s: "23/3", want: Conway},
+		{s: "S23/B3", want: Conway},
+		{s: "Life", want: Conway},
+		{s: "highlife", want: Rule{Birth: 1<<3 | 1<<6, Survive: 1<<2 | 1<<3, States: 2}},
+		{s: "seeds", want: Rule{Birth: 1 << 2, States: 2}},
+		{s: "B/S012345678", want: Rule{Survive: 0x1ff, States: 2}},
+		{s: "B2/S/C3", want: Rule{Birth: 1 << 2, States: 3}},
+		{s: "B2/S/G3", want: Rule{Birth: 1 << 2, States: 3}},
+		{s: "/2/3", want: Rule{Birth: 1 << 2, States: 3}},
+		{s: "briansbrain", want: Rule{Birth: 1 << 2, States: 3}},
+		{s: "starwars", want: Rule{Birth: 1 << 2, Survive: 1<<3 | 1<<4 | 1<<5, States: 4}},
+		{s: "B3/S23/C2", want: Conway},
+		{s: "B3", wantErr: true},
+		{s: "B3/S23/C3/X", wantErr: true},
+		{s: "B9/S23", wantErr: true},
+		{s: "B3/Sx", wantErr: true},
+		{s: "B3/S23/C1", wantErr: true},
+		{s: "B3/S23/Cx", wantErr: true},
+		{s: "nonsense", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.s, func(t *testing.T) {
+			rule, err := ParseRule(test.s)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("ParseRule(%q) = %v, want an error", test.s, rule)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseRule(%q) failed: %v", test.s, err)
+			}
+			if rule != test.want {
+				t.Errorf("ParseRule(%q) = %v, want %v", test.s, rule, test.want)
+			}
+		})
+	}
+}
+
+// TestRuleString checks that a rule written out reads back as the same rule.
+func TestRuleString(t *testing.T) {
+	for _, s := range []string{"B3/S23", "B36/S23", "B2/S", "B/S012345678", "B2/S/C3", "B2/S345/C4"} {
+		rule, err := ParseRule(s)
+		if err != nil {
+			t.Fatalf("ParseRule(%q) failed: %v", s, err)
+		}
+		if rule.String() != s {
+			t.Errorf("ParseRule(%q).String() = %q", s, rule.String())
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Topology is how the edges of the world are joined together.
+type Topology int
+
+const (
+	// Torus joins the left edge to the right and the top edge to the bottom.
+	Torus Topology = iota
+	// Plane has no joins: every cell beyond the edges is always dead.
+	Plane
+	// Cylinder joins the left edge to the right. Cells above and below the world are always dead.
+	Cylinder
+	// Klein joins the left edge to the right, and the top edge to the bottom with a twist,
+	// so that going off the top at x comes back at the bottom at width-1-x.
+	Klein
+)
+
+var topologyNames = []string{"torus", "plane", "cylinder", "klein"}
+
+// ParseTopology reads the name of a topology. An empty string is a torus.
+func ParseTopology(s string) (Topology, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return Torus, nil
+	}
+	for i, name := range topologyNames {
+		if s == name {
+			return Topology(i), nil
+		}
+	}
+	return Torus, fmt.Errorf("unknown topology %q, want one of %v", s, strings.Join(topologyNames, ", "))
+}
+
+// String returns the name of the topology.
+func (t Topology) String() string {
+	return topologyNames[t]
+}
+
+// WrapsX reports whether cells off the left and right edges are on the opposite edge.
+func (t Topology) WrapsX() bool {
+	return t != Plane
+}
+
+// WrapsY reports whether cells off the top and bottom edges are on the opposite edge.
+func (t Topology) WrapsY() bool {
+	return t == Torus || t == Klein
+}
+
+// MirrorsY reports whether the top and bottom edges are joined with a twist.
+func (t Topology) MirrorsY() bool {
+	return t == Klein
+}
+
+// MirrorRows returns copies of rows of the given width with each row reversed left to right.
+// The age planes of a Generations board are reversed along with the alive cells.
+func MirrorRows(width int, rows [][]uint64) [][]uint64 {
+	words := WordsPerRow(width)
+	mirrored := make([][]uint64, len(rows))
+	for y, row := range rows {
+		mirrored[y] = make([]uint64, len(row))
+		for plane := 0; plane < len(row)/words; plane++ {
+			for x := 0; x < width; x++ {
+				if row[plane*words+x/64]&(1<<uint(x%64)) != 0 {
+					m := width - 1 - x
+					mirrored[y][plane*words+m/64] |= 1 << uint(m%64)
+				}
+			}
+		}
+	}
+	return mirrored
+}
+
+// EdgeRows adjusts rows of the given width from the other side of the world that are passed
+// across its top or bottom edge, according to how those edges are joined.
+func (t Topology) EdgeRows(width int, rows [][]uint64) [][]uint64 {
+	switch {
+	case t.MirrorsY():
+		return MirrorRows(width, rows)
+	case !t.WrapsY():
+		dead := make([][]uint64, len(rows))
+		for y, row := range rows {
+			dead[y] = make([]uint64, len(row))
+		}
+		return dead
+	}
+	return rows
+}
@@ -0,0 +1,61 @@
+package util
+
+import "testing"
+
+// TestParseTopology checks every topology's name, and how its edges are joined.
+func TestParseTopology(t *testing.T) {
+	tests := []struct {
+		s                        string
+		want                     Topology
+		wrapsX, wrapsY, mirrorsY bool
+		wantErr                  bool
+	}{
+		{s: "", want: Torus, wrapsX: true, wrapsY: true},
+		{s: "torus", want: Torus, wrapsX: true, wrapsY: true},
+		{s: " Torus ", want: Torus, wrapsX: true, wrapsY: true},
+		{s: "plane", want: Plane},
+		{s: "cylinder", want: Cylinder, wrapsX: true},
+		{s: "KLEIN", want: Klein, wrapsX: true, wrapsY: true, mirrorsY: true},
+		{s: "sphere", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.s, func(t *testing.T) {
+			topology, err := ParseTopology(test.s)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("ParseTopology(%q) = %v, want an error", test.s, topology)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseTopology(%q) failed: %v", test.s, err)
+			}
+			if topology != test.want {
+				t.Errorf("ParseTopology(%q) = %v, want %v", test.s, topology, test.want)
+			}
+			if topology.WrapsX() != test.wrapsX || topology.WrapsY() != test.wrapsY || topology.MirrorsY() != test.mirrorsY {
+				t.Errorf("%v: WrapsX, WrapsY, MirrorsY = %v, %v, %v, want %v, %v, %v", topology,
+					topology.WrapsX(), topology.WrapsY(), topology.MirrorsY(), test.wrapsX, test.wrapsY, test.mirrorsY)
+			}
+			if back, _ := ParseTopology(topology.String()); back != topology {
+				t.Errorf("%v reads back as %v", topology, back)
+			}
+		})
+	}
+}
+
+// TestMirrorRows checks that mirroring reverses each plane of a row that is not a whole number
+// of words wide.
+func TestMirrorRows(t *testing.T) {
+	width := 70
+	board := NewStateBoard(width, 1, 3)
+	board.SetState(0, 0, 1)
+	board.SetState(3, 0, 2)
+	board.SetState(65, 0, 1)
+	mirrored := BitBoard{Width: width, Height: 1, AgePlanes: board.AgePlanes, Rows: MirrorRows(width, board.Rows)}
+	for x := 0; x < width; x++ {
+		if mirrored.State(width-1-x, 0) != board.State(x, 0) {
+			t.Errorf("cell %v has state %v, mirrored to %v with state %v", x, board.State(x, 0), width-1-x, mirrored.State(width-1-x, 0))
+		}
+	}
+}